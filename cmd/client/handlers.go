@@ -1,26 +1,35 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
 	"fmt"
 
-	amqp "github.com/rabbitmq/amqp091-go"
-
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
 )
 
+// promptAfter reprints the REPL prompt after a handler has printed over it.
+func promptAfter(next pubsub.Handler) pubsub.Handler {
+	return func(ctx context.Context, d pubsub.Delivery[any]) pubsub.Acktype {
+		defer fmt.Print("> ")
+		return next(ctx, d)
+	}
+}
+
 func handlerPause(gs *gamelogic.GameState) func(routing.PlayingState) pubsub.Acktype {
 	return func(ps routing.PlayingState) pubsub.Acktype {
-		defer fmt.Print("> ")
-		gs.HandlePause(ps)
+		if !gs.HandlePause(ps) {
+			fmt.Println("Ignoring stale pause state")
+		}
 		return pubsub.Ack
 	}
 }
 
-func handlerMove(gs *gamelogic.GameState, publishCh *amqp.Channel) func(move gamelogic.ArmyMove) pubsub.Acktype {
-	return func(move gamelogic.ArmyMove) pubsub.Acktype {
-		defer fmt.Print("> ")
+func handlerMove(gs *gamelogic.GameState, publishCh pubsub.Publisher) func(pubsub.Delivery[gamelogic.ArmyMove]) pubsub.Acktype {
+	return func(d pubsub.Delivery[gamelogic.ArmyMove]) pubsub.Acktype {
+		move := d.Value
 		outcome := gs.HandleMove(move)
 
 		switch outcome {
@@ -29,14 +38,17 @@ func handlerMove(gs *gamelogic.GameState, publishCh *amqp.Channel) func(move gam
 		case gamelogic.MoveOutComeSafe:
 			return pubsub.Ack
 		case gamelogic.MoveOutcomeMakeWar:
-			key := fmt.Sprintf("%s.%s", routing.WarRecognitionsPrefix, gs.GetUsername())
+			key := fmt.Sprintf("%s.%s.%s", routing.WarRecognitionsPrefix, move.Player.Username, gs.GetUsername())
+			defender := gs.GetPlayerSnap()
 			warMessage := gamelogic.RecognitionOfWar{
-				Attacker: move.Player,
-				Defender: gs.GetPlayerSnap(),
+				Attacker:  move.Player,
+				Defender:  defender,
+				Locations: gamelogic.OverlappingLocations(defender, move.Player),
 			}
-			if err := pubsub.PublishJSON(publishCh, routing.ExchangePerilTopic, key, warMessage); err != nil {
+			ctx := d.Context()
+			if err := pubsub.PublishWithContext(ctx, publishCh, routing.ExchangePerilTopic, key, warMessage); err != nil {
 				fmt.Printf("Failed to publish war message: %v\n", err)
-				return pubsub.NackRequeue
+				return pubsub.NackRetry
 			}
 			return pubsub.Ack
 		default:
@@ -46,37 +58,19 @@ func handlerMove(gs *gamelogic.GameState, publishCh *amqp.Channel) func(move gam
 	}
 }
 
-func handlerWar(gs *gamelogic.GameState, publishCh *amqp.Channel) func(gamelogic.RecognitionOfWar) pubsub.Acktype {
-	return func(rw gamelogic.RecognitionOfWar) pubsub.Acktype {
-		defer fmt.Print("> ")
-
-		outcome, winner, loser := gs.HandleWar(rw)
-		switch outcome {
-		case gamelogic.WarOutcomeNotInvolved:
-			return pubsub.NackRequeue
-		case gamelogic.WarOutcomeNoUnits:
-			return pubsub.NackDiscard
-		case gamelogic.WarOutcomeOpponentWon:
-			if err := publishGameLog(publishCh, gs.GetUsername(), fmt.Sprintf("%s won a war against %s", winner, loser)); err != nil {
-				fmt.Printf("Failed to publish log message: %v\n", err)
-				return pubsub.NackRequeue
-			}
-			return pubsub.Ack
-		case gamelogic.WarOutcomeYouWon:
-			if err := publishGameLog(publishCh, gs.GetUsername(), fmt.Sprintf("%s won a war against %s", winner, loser)); err != nil {
-				fmt.Printf("Failed to publish log message: %v\n", err)
-				return pubsub.NackRequeue
-			}
-			return pubsub.Ack
-		case gamelogic.WarOutcomeDraw:
-			if err := publishGameLog(publishCh, gs.GetUsername(), fmt.Sprintf("A war between %s and %s resulted in a draw", winner, loser)); err != nil {
-				fmt.Printf("Failed to publish log message: %v\n", err)
-				return pubsub.NackRequeue
-			}
-			return pubsub.Ack
-		default:
-			fmt.Println("Unknown war outcome")
+func handlerWar(gs *gamelogic.GameState, key ed25519.PublicKey) func(gamelogic.WarResult) pubsub.Acktype {
+	return func(result gamelogic.WarResult) pubsub.Acktype {
+		if err := result.Verify(key); err != nil {
+			fmt.Printf("Ignoring war result %s: %v\n", result.WarID, err)
 			return pubsub.NackDiscard
 		}
+		gs.ApplyWarReport(result.WarReport)
+		return pubsub.Ack
+	}
+}
+
+func handlerUnits(gs *gamelogic.GameState) func(pubsub.Delivery[struct{}]) (gamelogic.Player, error) {
+	return func(pubsub.Delivery[struct{}]) (gamelogic.Player, error) {
+		return gs.GetPlayerSnap(), nil
 	}
 }
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/topology"
+)
+
+func TestHandlerMoveDeclaresWar(t *testing.T) {
+	broker := pubsub.NewMemoryBroker()
+	defer broker.Close()
+	ch, err := broker.Channel()
+	if err != nil {
+		t.Fatalf("Channel: %v", err)
+	}
+	if err := topology.Peril().Apply(ch); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	gs := gamelogic.NewGameState("bob")
+	if _, err := gs.CommandSpawn([]string{"spawn", "europe", "infantry"}); err != nil {
+		t.Fatalf("CommandSpawn: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	moveKey := routing.ArmyMovesPrefix + ".*"
+	sub, err := pubsub.SubscribeDeliveryWithContext(ctx, broker, routing.ExchangePerilTopic, routing.ArmyMovesPrefix+".bob", moveKey, pubsub.QueueTransient, handlerMove(gs, ch))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer func() {
+		cancel()
+		sub.Wait()
+	}()
+
+	wars := make(chan pubsub.Delivery[gamelogic.RecognitionOfWar], 1)
+	warSub, err := pubsub.SubscribeDeliveryWithContext(ctx, broker, routing.ExchangePerilTopic, routing.WarRecognitionsPrefix, routing.WarRecognitionsPrefix+".*.*", pubsub.QueueDurable, func(d pubsub.Delivery[gamelogic.RecognitionOfWar]) pubsub.Acktype {
+		wars <- d
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer warSub.Close()
+
+	alice := gamelogic.Player{
+		Username: "alice",
+		Units:    map[int]gamelogic.Unit{1: {ID: 1, Rank: gamelogic.RankCavalry, Location: "europe"}},
+	}
+	move := gamelogic.ArmyMove{Player: alice, Units: []gamelogic.Unit{alice.Units[1]}, ToLocation: "europe"}
+	if err := pubsub.PublishJSON(ch, routing.ExchangePerilTopic, routing.ArmyMovesPrefix+".alice", move); err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+
+	select {
+	case d := <-wars:
+		if d.RoutingKey != "war.alice.bob" {
+			t.Errorf("war declared with key %s, want war.alice.bob", d.RoutingKey)
+		}
+		rw := d.Value
+		if rw.Attacker.Username != "alice" || rw.Defender.Username != "bob" {
+			t.Errorf("war between %s and %s, want alice attacking bob", rw.Attacker.Username, rw.Defender.Username)
+		}
+		if len(rw.Locations) != 1 || rw.Locations[0] != "europe" {
+			t.Errorf("war locations = %v, want [europe]", rw.Locations)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no war was declared")
+	}
+}
+
+func TestHandlerPauseIgnoresStaleState(t *testing.T) {
+	broker := pubsub.NewMemoryBroker()
+	defer broker.Close()
+	ch, err := broker.Channel()
+	if err != nil {
+		t.Fatalf("Channel: %v", err)
+	}
+	if err := topology.Peril().Apply(ch); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	gs := gamelogic.NewGameState("bob")
+	handled := make(chan struct{}, 2)
+	handle := handlerPause(gs)
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, err := pubsub.SubscribeJSONWithContext(ctx, broker, routing.ExchangePerilDirect, routing.PauseKey+".bob", routing.PauseKey, pubsub.QueueTransient, func(ps routing.PlayingState) pubsub.Acktype {
+		defer func() { handled <- struct{}{} }()
+		return handle(ps)
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer func() {
+		cancel()
+		sub.Wait()
+	}()
+
+	// The resume was sent first but arrives after the pause that followed it.
+	for _, ps := range []routing.PlayingState{{IsPaused: true, Version: 2}, {IsPaused: false, Version: 1}} {
+		if err := pubsub.PublishJSON(ch, routing.ExchangePerilDirect, routing.PauseKey, ps); err != nil {
+			t.Fatalf("PublishJSON: %v", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-handled:
+		case <-time.After(2 * time.Second):
+			t.Fatal("pause state was not handled")
+		}
+	}
+	if !gs.Paused || gs.PauseVersion != 2 {
+		t.Errorf("paused = %v at version %d, want the version 2 pause kept", gs.Paused, gs.PauseVersion)
+	}
+}
+
+func TestSyncPlayingStateCatchesUpLateJoiner(t *testing.T) {
+	broker := pubsub.NewMemoryBroker()
+	defer broker.Close()
+	ch, err := broker.Channel()
+	if err != nil {
+		t.Fatalf("Channel: %v", err)
+	}
+	if err := topology.Peril().Apply(ch); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serverState := routing.PlayingState{IsPaused: true, Version: 7}
+	sub, err := pubsub.Serve(ctx, broker, routing.ExchangePerilDirect, routing.RPCPauseStateKey, routing.RPCPauseStateKey, pubsub.QueueDurable, func(pubsub.Delivery[struct{}]) (routing.PlayingState, error) {
+		return serverState, nil
+	})
+	if err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	defer sub.Close()
+	rpc, err := pubsub.NewRPCClient(broker)
+	if err != nil {
+		t.Fatalf("NewRPCClient: %v", err)
+	}
+	defer rpc.Close()
+
+	// The game was paused before this client joined, so it missed the
+	// broadcast.
+	gs := gamelogic.NewGameState("carol")
+	if err := syncPlayingState(ctx, rpc, gs); err != nil {
+		t.Fatalf("syncPlayingState: %v", err)
+	}
+	if !gs.Paused || gs.PauseVersion != 7 {
+		t.Errorf("paused = %v at version %d, want the server's version 7 pause", gs.Paused, gs.PauseVersion)
+	}
+	// A broadcast from before the client synced is older than what it has.
+	if gs.HandlePause(routing.PlayingState{IsPaused: false, Version: 6}) {
+		t.Error("a resume older than the synced pause was applied")
+	}
+}
+
+func TestWarResultsOnlyReachThePlayersInvolved(t *testing.T) {
+	broker := pubsub.NewMemoryBroker()
+	defer broker.Close()
+	ch, err := broker.Channel()
+	if err != nil {
+		t.Fatalf("Channel: %v", err)
+	}
+	if err := topology.Peril().Apply(ch); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	type received struct {
+		username string
+		warID    string
+	}
+	got := make(chan received, 10)
+	for _, username := range []string{"alice", "bob", "carol"} {
+		sub, err := subscribeWarResults(ctx, broker, username, func(result gamelogic.WarResult) pubsub.Acktype {
+			got <- received{username, result.WarID}
+			return pubsub.Ack
+		})
+		if err != nil {
+			t.Fatalf("subscribeWarResults: %v", err)
+		}
+		defer sub.Close()
+	}
+
+	wars := []gamelogic.WarResult{
+		{WarID: "1", WarReport: gamelogic.WarReport{Attacker: "alice", Defender: "bob"}},
+		{WarID: "2", WarReport: gamelogic.WarReport{Attacker: "carol", Defender: "alice"}},
+		{WarID: "3", WarReport: gamelogic.WarReport{Attacker: "bob", Defender: "carol"}},
+	}
+	for _, war := range wars {
+		key := fmt.Sprintf("%s.%s.%s", routing.WarResultsPrefix, war.Attacker, war.Defender)
+		if err := pubsub.PublishJSON(ch, routing.ExchangePerilTopic, key, war); err != nil {
+			t.Fatalf("PublishJSON: %v", err)
+		}
+	}
+
+	want := map[received]bool{
+		{"alice", "1"}: true, {"bob", "1"}: true,
+		{"carol", "2"}: true, {"alice", "2"}: true,
+		{"bob", "3"}: true, {"carol", "3"}: true,
+	}
+	for len(want) > 0 {
+		select {
+		case r := <-got:
+			if !want[r] {
+				t.Fatalf("%s received war %s, which they are not in", r.username, r.warID)
+			}
+			delete(want, r)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("war results never arrived: %v", want)
+		}
+	}
+	select {
+	case r := <-got:
+		t.Errorf("%s received war %s again", r.username, r.warID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
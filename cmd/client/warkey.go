@@ -0,0 +1,23 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// loadWarPublicKey reads the hex-encoded key the server's war results are
+// verified with, as the server writes it next to its own key.
+func loadWarPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read war public key: %v", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%s does not hold a hex-encoded ed25519 public key", path)
+	}
+	return ed25519.PublicKey(key), nil
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+const (
+	consumerTag  = "perilctl"
+	fetchTimeout = 2 * time.Second
+	maxPrefetch  = 1000
+)
+
+// deadLetter is a message fetched from the DLQ. It stays unacked until the
+// command settles it; anything left unacked is requeued when the channel
+// closes.
+type deadLetter struct {
+	Index       int                    `json:"index"`
+	Exchange    string                 `json:"exchange"`
+	RoutingKey  string                 `json:"routing_key"`
+	Reason      string                 `json:"reason"`
+	Queue       string                 `json:"queue"`
+	Count       int64                  `json:"count"`
+	ContentType string                 `json:"content_type"`
+	MessageId   string                 `json:"message_id,omitempty"`
+	Headers     map[string]interface{} `json:"headers"`
+	Body        []byte                 `json:"body"`
+	Decoded     interface{}            `json:"decoded,omitempty"`
+	DecodeError string                 `json:"decode_error,omitempty"`
+
+	delivery amqp.Delivery
+}
+
+func runDLQ(broker pubsub.Broker, command string, args []string) error {
+	ch, err := broker.Channel()
+	if err != nil {
+		return fmt.Errorf("could not open channel: %v", err)
+	}
+	defer ch.Close()
+
+	switch command {
+	case "list":
+		fs := flag.NewFlagSet("list", flag.ExitOnError)
+		limit := fs.Int("limit", maxPrefetch, "maximum number of messages to show")
+		fs.Parse(args)
+		letters, err := fetchDeadLetters(ch, *limit)
+		if err != nil {
+			return err
+		}
+		printDeadLetters(letters)
+		return nil
+	case "replay":
+		fs := flag.NewFlagSet("replay", flag.ExitOnError)
+		all := fs.Bool("all", false, "replay every message")
+		fs.Parse(args)
+		letters, err := fetchSelected(ch, *all, fs.Args())
+		if err != nil {
+			return err
+		}
+		for _, dl := range letters {
+			if err := replay(ch, dl); err != nil {
+				return fmt.Errorf("could not replay message %s: %v", dl.MessageId, err)
+			}
+			fmt.Printf("Replayed message %s to %s with key %s\n", dl.MessageId, dl.Exchange, dl.RoutingKey)
+		}
+		return nil
+	case "purge":
+		fs := flag.NewFlagSet("purge", flag.ExitOnError)
+		all := fs.Bool("all", false, "purge every message")
+		fs.Parse(args)
+		letters, err := fetchSelected(ch, *all, fs.Args())
+		if err != nil {
+			return err
+		}
+		for _, dl := range letters {
+			if err := dl.delivery.Ack(false); err != nil {
+				return fmt.Errorf("could not purge message %s: %v", dl.MessageId, err)
+			}
+		}
+		fmt.Printf("Purged %d message(s)\n", len(letters))
+		return nil
+	case "export":
+		fs := flag.NewFlagSet("export", flag.ExitOnError)
+		limit := fs.Int("limit", maxPrefetch, "maximum number of messages to export")
+		fs.Parse(args)
+		if fs.NArg() != 1 {
+			return errors.New("usage: dlq export [-limit n] <file>")
+		}
+		letters, err := fetchDeadLetters(ch, *limit)
+		if err != nil {
+			return err
+		}
+		if err := export(fs.Arg(0), letters); err != nil {
+			return err
+		}
+		fmt.Printf("Exported %d message(s) to %s\n", len(letters), fs.Arg(0))
+		return nil
+	default:
+		usage()
+		return fmt.Errorf("unknown command: %s", command)
+	}
+}
+
+func fetchDeadLetters(ch pubsub.Channel, limit int) ([]*deadLetter, error) {
+	queue, err := ch.QueueDeclare(routing.QueuePerilDLQ, true, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not declare %s: %v", routing.QueuePerilDLQ, err)
+	}
+	count := queue.Messages
+	if limit > 0 && count > limit {
+		count = limit
+	}
+	if count > maxPrefetch {
+		count = maxPrefetch
+	}
+	if count == 0 {
+		return nil, nil
+	}
+
+	if err := ch.Qos(count, 0, false); err != nil {
+		return nil, fmt.Errorf("could not set QoS: %v", err)
+	}
+	deliveries, err := ch.Consume(queue.Name, consumerTag, false, true, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not consume %s: %v", queue.Name, err)
+	}
+	defer ch.Cancel(consumerTag, false)
+
+	letters := []*deadLetter{}
+	timeout := time.After(fetchTimeout)
+	for len(letters) < count {
+		select {
+		case delivery, ok := <-deliveries:
+			if !ok {
+				return letters, nil
+			}
+			letters = append(letters, newDeadLetter(len(letters)+1, delivery))
+		case <-timeout:
+			return letters, nil
+		}
+	}
+	return letters, nil
+}
+
+// fetchSelected fetches the dead letters with the given message IDs. IDs
+// rather than positions in a listing pick them, so messages dead-lettered or
+// settled since the listing can not shift the selection onto others.
+func fetchSelected(ch pubsub.Channel, all bool, ids []string) ([]*deadLetter, error) {
+	if !all && len(ids) == 0 {
+		return nil, errors.New("pass message IDs from 'dlq list' or -all")
+	}
+	letters, err := fetchDeadLetters(ch, maxPrefetch)
+	if err != nil {
+		return nil, err
+	}
+	if all {
+		return letters, nil
+	}
+	return selectByID(letters, ids)
+}
+
+// selectByID picks every letter with one of ids, failing if any ID matches
+// none.
+func selectByID(letters []*deadLetter, ids []string) ([]*deadLetter, error) {
+	wanted := map[string]bool{}
+	for _, id := range ids {
+		wanted[id] = false
+	}
+	selected := []*deadLetter{}
+	for _, dl := range letters {
+		if _, ok := wanted[dl.MessageId]; ok && dl.MessageId != "" {
+			wanted[dl.MessageId] = true
+			selected = append(selected, dl)
+		}
+	}
+	for _, id := range ids {
+		if !wanted[id] {
+			return nil, fmt.Errorf("no message with ID %q is in the dead letter queue", id)
+		}
+	}
+	return selected, nil
+}
+
+func newDeadLetter(index int, delivery amqp.Delivery) *deadLetter {
+	dl := &deadLetter{
+		Index:       index,
+		Exchange:    delivery.Exchange,
+		RoutingKey:  delivery.RoutingKey,
+		ContentType: delivery.ContentType,
+		MessageId:   delivery.MessageId,
+		Headers:     delivery.Headers,
+		Body:        delivery.Body,
+		delivery:    delivery,
+	}
+
+	if deaths, ok := delivery.Headers["x-death"].([]interface{}); ok && len(deaths) > 0 {
+		// The last entry is the first time the message was dead-lettered.
+		if first, ok := deaths[len(deaths)-1].(amqp.Table); ok {
+			dl.Exchange, _ = first["exchange"].(string)
+			dl.Queue, _ = first["queue"].(string)
+			dl.Reason, _ = first["reason"].(string)
+			if keys, ok := first["routing-keys"].([]interface{}); ok && len(keys) > 0 {
+				dl.RoutingKey, _ = keys[0].(string)
+			}
+		}
+		for _, d := range deaths {
+			if death, ok := d.(amqp.Table); ok {
+				count, _ := death["count"].(int64)
+				dl.Count += count
+			}
+		}
+	}
+	// Retries and poison handling republish the message, so the headers
+	// they add point at where it really came from.
+	if exchange, ok := delivery.Headers[pubsub.HeaderOriginalExchange].(string); ok {
+		dl.Exchange = exchange
+		dl.RoutingKey, _ = delivery.Headers[pubsub.HeaderOriginalRoutingKey].(string)
+		dl.Queue, _ = delivery.Headers[pubsub.HeaderOriginalQueue].(string)
+	}
+	if _, ok := delivery.Headers[pubsub.HeaderDecodeError]; ok {
+		dl.Reason = "undecodable"
+	}
+
+	dl.Decoded, dl.DecodeError = decodeBody(dl.RoutingKey, delivery.ContentType, delivery.Body)
+	return dl
+}
+
+// decodeBody decodes a message into the routing model type its original
+// routing key carries.
+func decodeBody(key, contentType string, body []byte) (interface{}, string) {
+	var val interface{}
+	prefix, _, _ := strings.Cut(key, ".")
+	switch prefix {
+	case routing.GameLogSlug:
+		val = &routing.GameLog{}
+	case routing.ArmyMovesPrefix:
+		val = &gamelogic.ArmyMove{}
+	case routing.ArmySpawnsPrefix:
+		val = &gamelogic.UnitSpawn{}
+	case routing.WarRecognitionsPrefix:
+		val = &gamelogic.RecognitionOfWar{}
+	case routing.WarResultsPrefix:
+		val = &gamelogic.WarResult{}
+	case routing.PauseKey:
+		val = &routing.PlayingState{}
+	default:
+		if contentType != pubsub.ContentTypeJSON {
+			return nil, "unknown message type"
+		}
+		val = &map[string]interface{}{}
+	}
+
+	codec, err := pubsub.DefaultCodecs.Lookup(contentType)
+	if err != nil {
+		return nil, err.Error()
+	}
+	if err := codec.Unmarshal(body, val); err != nil {
+		return nil, err.Error()
+	}
+	return val, ""
+}
+
+func printDeadLetters(letters []*deadLetter) {
+	if len(letters) == 0 {
+		fmt.Println("Dead letter queue is empty")
+		return
+	}
+	for _, dl := range letters {
+		id := dl.MessageId
+		if id == "" {
+			id = "(no message ID, select with -all)"
+		}
+		fmt.Printf("#%d %s: %s -> %s (queue %s, reason %s, dead-lettered %d time(s), %s)\n",
+			dl.Index, id, dl.RoutingKey, dl.Exchange, dl.Queue, dl.Reason, dl.Count, dl.ContentType)
+		if dl.DecodeError != "" {
+			fmt.Printf("    could not decode: %s\n", dl.DecodeError)
+			fmt.Printf("    %q\n", dl.Body)
+			continue
+		}
+		decoded, _ := json.Marshal(dl.Decoded)
+		fmt.Printf("    %s\n", decoded)
+	}
+}
+
+var deathHeaders = []string{
+	"x-death",
+	"x-first-death-queue",
+	"x-first-death-reason",
+	"x-first-death-exchange",
+	"x-last-death-queue",
+	"x-last-death-reason",
+	"x-last-death-exchange",
+	pubsub.HeaderRetryCount,
+	pubsub.HeaderDecodeError,
+	pubsub.HeaderDecodeFailedAt,
+	pubsub.HeaderOriginalExchange,
+	pubsub.HeaderOriginalRoutingKey,
+	pubsub.HeaderOriginalQueue,
+}
+
+func replay(ch pubsub.Channel, dl *deadLetter) error {
+	headers := amqp.Table{}
+	for k, v := range dl.delivery.Headers {
+		headers[k] = v
+	}
+	for _, k := range deathHeaders {
+		delete(headers, k)
+	}
+
+	d := dl.delivery
+	err := ch.PublishWithContext(context.Background(), dl.Exchange, dl.RoutingKey, false, false, amqp.Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    d.DeliveryMode,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	})
+	if err != nil {
+		return err
+	}
+	return d.Ack(false)
+}
+
+func export(path string, letters []*deadLetter) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create export file: %v", err)
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	for _, dl := range letters {
+		if err := encoder.Encode(dl); err != nil {
+			return fmt.Errorf("could not write message %d: %v", dl.Index, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func newTestDLQ(t *testing.T, ids ...string) pubsub.Broker {
+	t.Helper()
+	broker := pubsub.NewMemoryBroker()
+	t.Cleanup(func() { broker.Close() })
+	ch := openChannel(t, broker)
+	defer ch.Close()
+	if _, err := ch.QueueDeclare(routing.QueuePerilDLQ, true, false, false, false, nil); err != nil {
+		t.Fatalf("QueueDeclare: %v", err)
+	}
+	for _, id := range ids {
+		err := ch.PublishWithContext(context.Background(), "", routing.QueuePerilDLQ, false, false, amqp.Publishing{
+			ContentType: pubsub.ContentTypeJSON,
+			MessageId:   id,
+			Body:        []byte(`{}`),
+		})
+		if err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	return broker
+}
+
+// openChannel opens a channel for one command. Closing it, as perilctl does
+// on exit, requeues whatever the command left unacked.
+func openChannel(t *testing.T, broker pubsub.Broker) pubsub.Channel {
+	t.Helper()
+	ch, err := broker.Channel()
+	if err != nil {
+		t.Fatalf("Channel: %v", err)
+	}
+	return ch
+}
+
+func TestFetchSelectedByMessageID(t *testing.T) {
+	broker := newTestDLQ(t, "a", "b", "c")
+	ch := openChannel(t, broker)
+	letters, err := fetchSelected(ch, false, []string{"c", "a"})
+	if err != nil {
+		t.Fatalf("fetchSelected: %v", err)
+	}
+	got := []string{}
+	for _, dl := range letters {
+		got = append(got, dl.MessageId)
+		if err := dl.delivery.Ack(false); err != nil {
+			t.Fatalf("Ack: %v", err)
+		}
+	}
+	ch.Close()
+	if len(got) != 2 || got[0] != "a" || got[1] != "c" {
+		t.Fatalf("selected %v, want [a c]", got)
+	}
+
+	// Once a and c are purged, b is the first message listed; selecting by
+	// position would now pick it by mistake.
+	ch = openChannel(t, broker)
+	if _, err := fetchSelected(ch, false, []string{"a"}); err == nil {
+		t.Error("selecting a purged message succeeded")
+	}
+	ch.Close()
+	ch = openChannel(t, broker)
+	defer ch.Close()
+	letters, err = fetchSelected(ch, false, []string{"b"})
+	if err != nil || len(letters) != 1 || letters[0].MessageId != "b" {
+		t.Errorf("fetchSelected(b) = %v, %v, want b", letters, err)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/tracing"
+)
+
+// runTrace prints the spans in a file written by a FileExporter as one tree
+// per trace, so a move can be followed through the wars and logs it caused.
+func runTrace(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: trace <file> [trace-id]")
+	}
+	spans, err := readSpans(args[0])
+	if err != nil {
+		return err
+	}
+
+	traces := map[string][]tracing.SpanData{}
+	for _, span := range spans {
+		if len(args) == 2 && span.TraceID != args[1] {
+			continue
+		}
+		traces[span.TraceID] = append(traces[span.TraceID], span)
+	}
+	if len(traces) == 0 {
+		fmt.Println("No spans found")
+		return nil
+	}
+
+	ids := make([]string, 0, len(traces))
+	for id, spans := range traces {
+		sort.Slice(spans, func(i, j int) bool { return spans[i].Start.Before(spans[j].Start) })
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return traces[ids[i]][0].Start.Before(traces[ids[j]][0].Start) })
+
+	for _, id := range ids {
+		printTrace(id, traces[id])
+	}
+	return nil
+}
+
+func readSpans(path string) ([]tracing.SpanData, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open trace file: %v", err)
+	}
+	defer f.Close()
+
+	spans := []tracing.SpanData{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var span tracing.SpanData
+		if err := json.Unmarshal(scanner.Bytes(), &span); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		spans = append(spans, span)
+	}
+	return spans, scanner.Err()
+}
+
+func printTrace(id string, spans []tracing.SpanData) {
+	start := spans[0].Start
+	known := map[string]bool{}
+	children := map[string][]tracing.SpanData{}
+	for _, span := range spans {
+		known[span.SpanID] = true
+	}
+	roots := []tracing.SpanData{}
+	for _, span := range spans {
+		if span.ParentSpanID == "" || !known[span.ParentSpanID] {
+			roots = append(roots, span)
+			continue
+		}
+		children[span.ParentSpanID] = append(children[span.ParentSpanID], span)
+	}
+
+	fmt.Printf("trace %s (%d spans)\n", id, len(spans))
+	var walk func(span tracing.SpanData, depth int)
+	walk = func(span tracing.SpanData, depth int) {
+		fmt.Printf("%s%s +%v %v%s\n",
+			strings.Repeat("  ", depth+1),
+			span.Name,
+			span.Start.Sub(start).Round(time.Microsecond),
+			span.End.Sub(span.Start).Round(time.Microsecond),
+			spanDetails(span),
+		)
+		for _, child := range children[span.SpanID] {
+			walk(child, depth+1)
+		}
+	}
+	for _, root := range roots {
+		walk(root, 0)
+	}
+}
+
+func spanDetails(span tracing.SpanData) string {
+	details := []string{}
+	if sender := span.Attributes["peril.sender"]; sender != "" {
+		details = append(details, "from "+sender)
+	}
+	if acktype := span.Attributes["peril.acktype"]; acktype != "" {
+		details = append(details, acktype)
+	}
+	if span.Error != "" {
+		details = append(details, "error: "+span.Error)
+	}
+	if len(details) == 0 {
+		return ""
+	}
+	return " [" + strings.Join(details, ", ") + "]"
+}
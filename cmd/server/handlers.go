@@ -1,20 +1,151 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
 )
 
+// promptAfter reprints the REPL prompt after a handler has printed over it.
+func promptAfter(next pubsub.Handler) pubsub.Handler {
+	return func(ctx context.Context, d pubsub.Delivery[any]) pubsub.Acktype {
+		defer fmt.Print("> ")
+		return next(ctx, d)
+	}
+}
+
 func handlerLog() func(routing.GameLog) pubsub.Acktype {
 	return func(gamelog routing.GameLog) pubsub.Acktype {
-		defer fmt.Print("> ")
 		if err := gamelogic.WriteLog(gamelog); err != nil {
 			fmt.Printf("Error writing log: %v\n", err)
-			return pubsub.NackRequeue
+			return pubsub.NackRetry
+		}
+		return pubsub.Ack
+	}
+}
+
+func handlerPause(state *pauseState) func(routing.PlayingState) pubsub.Acktype {
+	return func(ps routing.PlayingState) pubsub.Acktype {
+		state.apply(ps)
+		return pubsub.Ack
+	}
+}
+
+func handlerPauseState(state *pauseState) func(pubsub.Delivery[struct{}]) (routing.PlayingState, error) {
+	return func(pubsub.Delivery[struct{}]) (routing.PlayingState, error) {
+		return state.get(), nil
+	}
+}
+
+func handlerSpawn(roster *gamelogic.Roster) func(gamelogic.UnitSpawn) pubsub.Acktype {
+	return func(spawn gamelogic.UnitSpawn) pubsub.Acktype {
+		if err := roster.ApplySpawn(spawn); err != nil {
+			fmt.Printf("Rejected spawn from %s: %v\n", spawn.Username, err)
+			return pubsub.NackDiscard
+		}
+		return pubsub.Ack
+	}
+}
+
+func handlerMove(roster *gamelogic.Roster, victory *victoryWatch) func(gamelogic.ArmyMove) pubsub.Acktype {
+	return func(move gamelogic.ArmyMove) pubsub.Acktype {
+		if err := roster.ApplyMove(move); err != nil {
+			fmt.Printf("Rejected move from %s: %v\n", move.Player.Username, err)
+			return pubsub.NackDiscard
+		}
+		victory.check()
+		return pubsub.Ack
+	}
+}
+
+// handlerRoster answers other servers asking for a snapshot of the roster.
+func handlerRoster(roster *gamelogic.Roster) func(pubsub.Delivery[struct{}]) ([]gamelogic.RosterEntry, error) {
+	return func(pubsub.Delivery[struct{}]) ([]gamelogic.RosterEntry, error) {
+		return roster.Snapshot(), nil
+	}
+}
+
+// handlerWar resolves declared wars against the roster and announces the
+// signed result to both players, logging it through logCh.
+func handlerWar(rosters *rosterSync, key ed25519.PrivateKey, publishCh, logCh pubsub.Publisher) func(pubsub.Delivery[gamelogic.RecognitionOfWar]) pubsub.Acktype {
+	roster := rosters.roster
+	return func(d pubsub.Delivery[gamelogic.RecognitionOfWar]) pubsub.Acktype {
+		for _, username := range []string{d.Value.Attacker.Username, d.Value.Defender.Username} {
+			if err := rosters.ensure(d.Context(), username); err != nil {
+				fmt.Printf("Failed to rebuild the roster: %v\n", err)
+				return pubsub.NackRetry
+			}
+		}
+		result := gamelogic.WarResult{
+			WarID:      d.MessageID,
+			WarReport:  roster.ResolveWar(d.Value),
+			ResolvedAt: time.Now().UTC(),
+		}
+		if len(result.Battles) == 0 {
+			// The move that started the war may not have reached this
+			// server yet.
+			fmt.Printf("%s and %s have no units in the same location\n", result.Attacker, result.Defender)
+			return pubsub.NackRetry
+		}
+		if missing := result.MissingFronts(d.Value.Locations); len(missing) > 0 {
+			// Nor may the moves behind some of the fronts the attacker saw.
+			fmt.Printf("%s and %s have no units in %v yet\n", result.Attacker, result.Defender, missing)
+			return pubsub.NackRetry
+		}
+		if err := result.Sign(key); err != nil {
+			fmt.Printf("Failed to sign war result: %v\n", err)
+			return pubsub.NackDiscard
+		}
+
+		ctx := d.Context()
+		resultKey := fmt.Sprintf("%s.%s.%s", routing.WarResultsPrefix, result.Attacker, result.Defender)
+		if err := pubsub.PublishWithContext(ctx, publishCh, routing.ExchangePerilTopic, resultKey, result); err != nil {
+			fmt.Printf("Failed to publish war result: %v\n", err)
+			return pubsub.NackRetry
 		}
+		roster.ApplyWarReport(result.WarReport)
+
+		// The result is out, so a lost log is not worth fighting the war again.
+		if err := publishGameLog(ctx, logCh, result.Attacker, result.Summary()); err != nil {
+			fmt.Printf("Failed to publish log message: %v\n", err)
+		}
+		return pubsub.Ack
+	}
+}
+
+// handlerWarResult applies every server's war results to this server's
+// roster, so all servers agree on who has which units.
+func handlerWarResult(roster *gamelogic.Roster, key ed25519.PublicKey, victory *victoryWatch) func(gamelogic.WarResult) pubsub.Acktype {
+	return func(result gamelogic.WarResult) pubsub.Acktype {
+		if err := result.Verify(key); err != nil {
+			fmt.Printf("Ignoring war result %s: %v\n", result.WarID, err)
+			return pubsub.NackDiscard
+		}
+		roster.ApplyWarReport(result.WarReport)
+		victory.check()
 		return pubsub.Ack
 	}
 }
+
+// victoryWatch announces the winner of the game, once, as soon as the
+// roster shows the scenario's victory conditions are met.
+type victoryWatch struct {
+	roster *gamelogic.Roster
+	once   sync.Once
+}
+
+func (v *victoryWatch) check() {
+	winner, ok := v.roster.Victor()
+	if !ok {
+		return
+	}
+	v.once.Do(func() {
+		fmt.Printf("==== %s has won the game! ====\n", winner)
+	})
+}
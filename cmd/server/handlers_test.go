@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/topology"
+)
+
+const testTimeout = 2 * time.Second
+
+// startHandlerWar runs handlerWar against roster on a fresh broker and
+// returns a channel to declare wars on, the key to verify results with and
+// the results published.
+func startHandlerWar(t *testing.T, roster *gamelogic.Roster, opts ...pubsub.SubscribeOption) (pubsub.Channel, ed25519.PublicKey, <-chan pubsub.Delivery[gamelogic.WarResult]) {
+	t.Helper()
+	broker := pubsub.NewMemoryBroker()
+	t.Cleanup(func() { broker.Close() })
+	ch, err := broker.Channel()
+	if err != nil {
+		t.Fatalf("Channel: %v", err)
+	}
+	if err := topology.Peril().Apply(ch); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	results := make(chan pubsub.Delivery[gamelogic.WarResult], 1)
+	resultSub, err := pubsub.SubscribeDeliveryWithContext(ctx, broker, routing.ExchangePerilTopic, "results", routing.WarResultsPrefix+".*.*", pubsub.QueueTransient, func(d pubsub.Delivery[gamelogic.WarResult]) pubsub.Acktype {
+		results <- d
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	t.Cleanup(func() { resultSub.Close() })
+
+	warSub, err := pubsub.SubscribeDeliveryWithContext(ctx, broker, routing.ExchangePerilTopic, routing.WarRecognitionsPrefix, routing.WarRecognitionsPrefix+".*.*", pubsub.QueueDurable, handlerWar(&rosterSync{roster: roster}, private, ch, pubsub.WithCodec(ch, pubsub.GobCodec{})), opts...)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	t.Cleanup(func() { warSub.Close() })
+	return ch, public, results
+}
+
+func spawnAll(t *testing.T, roster *gamelogic.Roster, spawns ...gamelogic.UnitSpawn) {
+	t.Helper()
+	for _, spawn := range spawns {
+		if err := roster.ApplySpawn(spawn); err != nil {
+			t.Fatalf("ApplySpawn: %v", err)
+		}
+	}
+}
+
+func TestHandlerWarPublishesSignedResult(t *testing.T) {
+	roster := gamelogic.NewRoster()
+	spawnAll(t, roster,
+		gamelogic.UnitSpawn{Username: "alice", Unit: gamelogic.Unit{ID: 1, Rank: gamelogic.RankArtillery, Location: "europe"}},
+		gamelogic.UnitSpawn{Username: "bob", Unit: gamelogic.Unit{ID: 1, Rank: gamelogic.RankInfantry, Location: "europe"}},
+	)
+	ch, public, results := startHandlerWar(t, roster)
+
+	rw := gamelogic.RecognitionOfWar{
+		Attacker:  gamelogic.Player{Username: "alice"},
+		Defender:  gamelogic.Player{Username: "bob"},
+		Locations: []gamelogic.Location{"europe"},
+	}
+	if err := pubsub.PublishJSON(ch, routing.ExchangePerilTopic, routing.WarRecognitionsPrefix+".alice.bob", rw); err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+
+	select {
+	case d := <-results:
+		if d.RoutingKey != "war_results.alice.bob" {
+			t.Errorf("result published with key %s, want war_results.alice.bob", d.RoutingKey)
+		}
+		result := d.Value
+		if err := result.Verify(public); err != nil {
+			t.Errorf("Verify: %v", err)
+		}
+		if winner, _, draw := result.Result(); winner != "alice" || draw {
+			t.Errorf("winner = %s, draw = %v, want alice to win", winner, draw)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("no war result was published")
+	}
+
+	// The server applies its own ruling once the result is out, so bob's
+	// infantry goes.
+	deadline := time.Now().Add(testTimeout)
+	for len(roster.ResolveWar(rw).Battles) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("roster still has bob's infantry in europe after the war")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHandlerWarWaitsForEveryDeclaredFront(t *testing.T) {
+	roster := gamelogic.NewRoster()
+	spawnAll(t, roster,
+		gamelogic.UnitSpawn{Username: "alice", Unit: gamelogic.Unit{ID: 1, Rank: gamelogic.RankArtillery, Location: "europe"}},
+		gamelogic.UnitSpawn{Username: "bob", Unit: gamelogic.Unit{ID: 1, Rank: gamelogic.RankInfantry, Location: "europe"}},
+		gamelogic.UnitSpawn{Username: "alice", Unit: gamelogic.Unit{ID: 2, Rank: gamelogic.RankInfantry, Location: "asia"}},
+	)
+	ch, _, results := startHandlerWar(t, roster, pubsub.WithRetryPolicy(pubsub.RetryPolicy{
+		MaxAttempts: 10,
+		Backoff:     pubsub.Backoff{Initial: 20 * time.Millisecond, Max: 20 * time.Millisecond, Multiplier: 1},
+	}))
+
+	// alice saw bob move into asia, but this server has not yet.
+	rw := gamelogic.RecognitionOfWar{
+		Attacker:  gamelogic.Player{Username: "alice"},
+		Defender:  gamelogic.Player{Username: "bob"},
+		Locations: []gamelogic.Location{"asia", "europe"},
+	}
+	if err := pubsub.PublishJSON(ch, routing.ExchangePerilTopic, routing.WarRecognitionsPrefix+".alice.bob", rw); err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+	select {
+	case d := <-results:
+		t.Fatalf("war was fought on %d fronts before bob's move reached the server", len(d.Value.Battles))
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	spawnAll(t, roster, gamelogic.UnitSpawn{Username: "bob", Unit: gamelogic.Unit{ID: 2, Rank: gamelogic.RankInfantry, Location: "asia"}})
+	select {
+	case d := <-results:
+		if got := d.Value.MissingFronts(rw.Locations); len(got) != 0 {
+			t.Errorf("war was fought without a battle in %v", got)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("war was not fought once the server saw every front")
+	}
+}
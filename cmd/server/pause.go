@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/topology"
+)
+
+// pauseState is the server's copy of the canonical playing state. Every
+// server applies every pause broadcast, so any of them can answer clients.
+type pauseState struct {
+	mu    sync.Mutex
+	state routing.PlayingState
+}
+
+func (s *pauseState) get() routing.PlayingState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// apply replaces the state unless ps is older than it.
+func (s *pauseState) apply(ps routing.PlayingState) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !ps.Supersedes(s.state) {
+		return false
+	}
+	s.state = ps
+	return true
+}
+
+// next returns a state that supersedes the current one. Versions are
+// timestamps so that servers which never saw each other's changes still
+// order them, and never go backwards if a clock does.
+func (s *pauseState) next(paused bool) routing.PlayingState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	version := max(s.state.Version+1, uint64(time.Now().UnixNano()))
+	return routing.PlayingState{IsPaused: paused, Version: version}
+}
+
+const pauseStateLoadTimeout = 5 * time.Second
+
+// loadPauseState reads the last pause broadcast from the retained queue
+// without removing it, so a restarted server picks up where the game was.
+func loadPauseState(broker pubsub.Broker) (routing.PlayingState, error) {
+	var ps routing.PlayingState
+	ch, err := broker.Channel()
+	if err != nil {
+		return ps, fmt.Errorf("Failed to open a channel: %v", err)
+	}
+	// Closing the channel returns the unacked message to the queue.
+	defer ch.Close()
+
+	q, _ := topology.Peril().Queue(routing.QueuePerilPauseState)
+	queue, err := ch.QueueDeclare(q.Name, q.Durable, q.AutoDelete, q.Exclusive, false, q.Args)
+	if err != nil {
+		return ps, fmt.Errorf("Failed to declare queue: %v", err)
+	}
+	if queue.Messages == 0 {
+		return ps, nil
+	}
+
+	if err := ch.Qos(1, 0, false); err != nil {
+		return ps, fmt.Errorf("Failed to set QoS: %v", err)
+	}
+	deliveries, err := ch.Consume(queue.Name, "", false, false, false, false, nil)
+	if err != nil {
+		return ps, fmt.Errorf("Failed to consume queue: %v", err)
+	}
+	select {
+	case d, ok := <-deliveries:
+		if !ok {
+			return ps, fmt.Errorf("channel closed while reading pause state")
+		}
+		codec, err := pubsub.DefaultCodecs.Lookup(d.ContentType)
+		if err != nil {
+			return ps, err
+		}
+		err = codec.Unmarshal(d.Body, &ps)
+		return ps, err
+	case <-time.After(pauseStateLoadTimeout):
+		return ps, fmt.Errorf("timed out reading pause state")
+	}
+}
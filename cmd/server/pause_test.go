@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func TestLoadPauseStateReadsTheLatestBroadcast(t *testing.T) {
+	broker := newTestBroker(t)
+	ch, err := broker.Channel()
+	if err != nil {
+		t.Fatalf("Channel: %v", err)
+	}
+	defer ch.Close()
+
+	if ps, err := loadPauseState(broker); err != nil || ps != (routing.PlayingState{}) {
+		t.Errorf("loadPauseState = %+v, %v before any broadcast, want the zero state", ps, err)
+	}
+	for version := uint64(1); version <= 3; version++ {
+		ps := routing.PlayingState{IsPaused: version%2 == 1, Version: version}
+		if err := pubsub.PublishJSON(ch, routing.ExchangePerilDirect, routing.PauseKey, ps); err != nil {
+			t.Fatalf("PublishJSON: %v", err)
+		}
+	}
+
+	// peril_pause_state keeps only the latest broadcast, and reading it
+	// leaves it there for the next server to start.
+	want := routing.PlayingState{IsPaused: true, Version: 3}
+	for i := 0; i < 2; i++ {
+		ps, err := loadPauseState(broker)
+		if err != nil {
+			t.Fatalf("loadPauseState: %v", err)
+		}
+		if ps != want {
+			t.Errorf("loadPauseState = %+v, want %+v", ps, want)
+		}
+	}
+}
+
+func TestPauseStateIgnoresStaleBroadcasts(t *testing.T) {
+	state := &pauseState{}
+	handle := handlerPause(state)
+	handle(routing.PlayingState{IsPaused: true, Version: 2})
+	handle(routing.PlayingState{IsPaused: false, Version: 1})
+	if got := state.get(); !got.IsPaused || got.Version != 2 {
+		t.Errorf("state = %+v, want the version 2 pause kept", got)
+	}
+	if next := state.next(false); next.Version <= 2 {
+		t.Errorf("next version %d does not supersede 2", next.Version)
+	}
+}
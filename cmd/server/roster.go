@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+const rosterLoadTimeout = 5 * time.Second
+
+// rosterSync rebuilds the roster of a server that starts after the game
+// has. It asks another server for a snapshot of theirs. With none left to
+// ask, players the roster has never seen are asked for their units before
+// the first war they fight; the record of any player it has seen stands.
+type rosterSync struct {
+	roster *gamelogic.Roster
+	rpc    *pubsub.RPCClient
+	ready  chan struct{}
+
+	mu sync.Mutex
+	// askPlayers is set when no other server sent its roster.
+	askPlayers bool
+}
+
+func newRosterSync(roster *gamelogic.Roster, rpc *pubsub.RPCClient) *rosterSync {
+	return &rosterSync{
+		roster: roster,
+		rpc:    rpc,
+		ready:  make(chan struct{}),
+	}
+}
+
+// load restores the roster from another server's, if one answers, and lets
+// the handlers waiting on it go.
+func (s *rosterSync) load(ctx context.Context) {
+	defer close(s.ready)
+	ctx, cancel := context.WithTimeout(ctx, rosterLoadTimeout)
+	defer cancel()
+	entries, err := pubsub.Call[struct{}, []gamelogic.RosterEntry](ctx, s.rpc, routing.ExchangePerilDirect, routing.RPCRosterKey, struct{}{})
+	if err != nil {
+		fmt.Printf("No other server sent its roster (%v), asking players for their units instead\n", err)
+		s.mu.Lock()
+		s.askPlayers = true
+		s.mu.Unlock()
+		return
+	}
+	s.roster.Restore(entries)
+	fmt.Printf("Restored the roster of %d players from another server\n", len(entries))
+}
+
+// wait holds deliveries back until the roster is loaded, so that updates
+// are applied on top of it rather than overwritten by it.
+func (s *rosterSync) wait(next pubsub.Handler) pubsub.Handler {
+	return func(ctx context.Context, d pubsub.Delivery[any]) pubsub.Acktype {
+		select {
+		case <-s.ready:
+			return next(ctx, d)
+		case <-ctx.Done():
+			return pubsub.NackRequeue
+		}
+	}
+}
+
+// ensure asks username for their units if the roster has never seen them
+// and no other server's roster was restored.
+func (s *rosterSync) ensure(ctx context.Context, username string) error {
+	s.mu.Lock()
+	askPlayers := s.askPlayers
+	s.mu.Unlock()
+	if !askPlayers || s.roster.Knows(username) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, rosterLoadTimeout)
+	defer cancel()
+	key := fmt.Sprintf("%s.%s", routing.RPCUnitsPrefix, username)
+	player, err := pubsub.Call[struct{}, gamelogic.Player](ctx, s.rpc, routing.ExchangePerilDirect, key, struct{}{})
+	if err != nil {
+		return fmt.Errorf("asking %s for their units: %w", username, err)
+	}
+	if player.Username != username {
+		return fmt.Errorf("asked %s for their units and %s answered", username, player.Username)
+	}
+	// Another war, or a spawn, may have recorded the player while they were
+	// being asked; that record stands.
+	if err := s.roster.RestorePlayer(player); err != nil && !errors.Is(err, gamelogic.ErrPlayerKnown) {
+		return fmt.Errorf("restoring %s's units: %w", username, err)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/topology"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func newTestBroker(t *testing.T) *pubsub.MemoryBroker {
+	t.Helper()
+	broker := pubsub.NewMemoryBroker()
+	t.Cleanup(func() { broker.Close() })
+	ch, err := broker.Channel()
+	if err != nil {
+		t.Fatalf("Channel: %v", err)
+	}
+	if err := topology.Peril().Apply(ch); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	return broker
+}
+
+func newTestRosterSync(t *testing.T, broker pubsub.Broker) *rosterSync {
+	t.Helper()
+	rpc, err := pubsub.NewRPCClient(broker)
+	if err != nil {
+		t.Fatalf("NewRPCClient: %v", err)
+	}
+	t.Cleanup(func() { rpc.Close() })
+	return newRosterSync(gamelogic.NewRoster(), rpc)
+}
+
+func unitsIn(roster *gamelogic.Roster, username string) int {
+	for _, entry := range roster.Snapshot() {
+		if entry.Player.Username == username {
+			return len(entry.Player.Units)
+		}
+	}
+	return 0
+}
+
+func TestRosterSyncRestoresFromAnotherServer(t *testing.T) {
+	broker := newTestBroker(t)
+	peer := gamelogic.NewRoster()
+	if err := peer.ApplySpawn(gamelogic.UnitSpawn{Username: "alice", Unit: gamelogic.Unit{ID: 1, Rank: gamelogic.RankInfantry, Location: "europe"}}); err != nil {
+		t.Fatalf("ApplySpawn: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub, err := pubsub.Serve(ctx, broker, routing.ExchangePerilDirect, routing.RPCRosterKey, routing.RPCRosterKey, pubsub.QueueDurable, handlerRoster(peer))
+	if err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	defer sub.Close()
+
+	rosters := newTestRosterSync(t, broker)
+	rosters.load(ctx)
+	if got := unitsIn(rosters.roster, "alice"); got != 1 {
+		t.Errorf("restored roster has %d of alice's units, want 1", got)
+	}
+	// A roster restored from another server's needs nothing from the
+	// players, who are not even listening here.
+	if err := rosters.ensure(ctx, "alice"); err != nil {
+		t.Errorf("ensure: %v", err)
+	}
+	// The restored roster still refuses a spawn it has already seen.
+	err = rosters.roster.ApplySpawn(gamelogic.UnitSpawn{Username: "alice", Unit: gamelogic.Unit{ID: 1, Rank: gamelogic.RankInfantry, Location: "europe"}})
+	if err == nil {
+		t.Error("restored roster accepted a unit ID alice already has")
+	}
+}
+
+// serveUnits answers rpc.units.<username> with units, after release is
+// closed, and counts the requests.
+func serveUnits(t *testing.T, ctx context.Context, broker pubsub.Broker, username string, units map[int]gamelogic.Unit, release <-chan struct{}) *atomic.Int32 {
+	t.Helper()
+	asked := &atomic.Int32{}
+	key := fmt.Sprintf("%s.%s", routing.RPCUnitsPrefix, username)
+	sub, err := pubsub.Serve(ctx, broker, routing.ExchangePerilDirect, key, key, pubsub.QueueTransient, func(pubsub.Delivery[struct{}]) (gamelogic.Player, error) {
+		asked.Add(1)
+		<-release
+		return gamelogic.Player{Username: username, Units: units}, nil
+	})
+	if err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	t.Cleanup(func() { sub.Close() })
+	return asked
+}
+
+func TestRosterSyncAsksPlayersWithNoServerLeft(t *testing.T) {
+	broker := newTestBroker(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	released := make(chan struct{})
+	close(released)
+	asked := serveUnits(t, ctx, broker, "alice", map[int]gamelogic.Unit{
+		3: {ID: 3, Rank: gamelogic.RankCavalry, Location: "asia"},
+	}, released)
+
+	rosters := newTestRosterSync(t, broker)
+	rosters.load(ctx)
+	for i := 0; i < 2; i++ {
+		if err := rosters.ensure(ctx, "alice"); err != nil {
+			t.Fatalf("ensure: %v", err)
+		}
+	}
+	if n := asked.Load(); n != 1 {
+		t.Errorf("alice was asked for units %d times, want once", n)
+	}
+	if got := unitsIn(rosters.roster, "alice"); got != 1 {
+		t.Errorf("rebuilt roster has %d of alice's units, want 1", got)
+	}
+	// New units must not reuse the IDs of the ones claimed.
+	err := rosters.roster.ApplySpawn(gamelogic.UnitSpawn{Username: "alice", Unit: gamelogic.Unit{ID: 3, Rank: gamelogic.RankInfantry, Location: "europe"}})
+	if err == nil {
+		t.Error("rebuilt roster accepted a unit ID alice already has")
+	}
+}
+
+func TestRosterSyncKeepsRecordOfKnownPlayers(t *testing.T) {
+	broker := newTestBroker(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	released := make(chan struct{})
+	close(released)
+	// alice claims an artillery the roster never saw spawn.
+	asked := serveUnits(t, ctx, broker, "alice", map[int]gamelogic.Unit{
+		1: {ID: 1, Rank: gamelogic.RankArtillery, Location: "europe"},
+	}, released)
+
+	rosters := newTestRosterSync(t, broker)
+	rosters.load(ctx)
+	if err := rosters.roster.ApplySpawn(gamelogic.UnitSpawn{Username: "alice", Unit: gamelogic.Unit{ID: 1, Rank: gamelogic.RankInfantry, Location: "europe"}}); err != nil {
+		t.Fatalf("ApplySpawn: %v", err)
+	}
+	if err := rosters.ensure(ctx, "alice"); err != nil {
+		t.Fatalf("ensure: %v", err)
+	}
+	if n := asked.Load(); n != 0 {
+		t.Errorf("alice was asked for units %d times, want never", n)
+	}
+	for _, entry := range rosters.roster.Snapshot() {
+		if unit := entry.Player.Units[1]; entry.Player.Username == "alice" && unit.Rank != gamelogic.RankInfantry {
+			t.Errorf("alice's unit 1 is %s, want the infantry the roster saw spawn", unit.Rank)
+		}
+	}
+}
+
+func TestRosterSyncDoesNotWaitOnOtherPlayers(t *testing.T) {
+	broker := newTestBroker(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	slow := make(chan struct{})
+	defer close(slow)
+	serveUnits(t, ctx, broker, "bob", nil, slow)
+	released := make(chan struct{})
+	close(released)
+	serveUnits(t, ctx, broker, "alice", nil, released)
+
+	rosters := newTestRosterSync(t, broker)
+	rosters.load(ctx)
+	go rosters.ensure(ctx, "bob")
+
+	done := make(chan error, 1)
+	go func() { done <- rosters.ensure(ctx, "alice") }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("ensure: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("asking alice waited on bob")
+	}
+}
+
+func TestRosterSyncWaitRequeuesWhenClosed(t *testing.T) {
+	broker := newTestBroker(t)
+	rosters := newTestRosterSync(t, broker)
+	handled := make(chan struct{}, 1)
+	sub, err := pubsub.SubscribeJSONWithContext(context.Background(), broker, routing.ExchangePerilTopic, "waiting", "army_moves.*", pubsub.QueueDurable, func(gamelogic.ArmyMove) pubsub.Acktype {
+		handled <- struct{}{}
+		return pubsub.Ack
+	}, pubsub.WithMiddleware(rosters.wait))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	ch, err := broker.Channel()
+	if err != nil {
+		t.Fatalf("Channel: %v", err)
+	}
+	defer ch.Close()
+	if err := pubsub.PublishJSON(ch, routing.ExchangePerilTopic, "army_moves.alice", gamelogic.ArmyMove{}); err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+	// The roster is never loaded, so the move waits until the subscription
+	// is closed and goes back on the queue for the next server.
+	time.Sleep(50 * time.Millisecond)
+	sub.Close()
+	select {
+	case <-handled:
+		t.Error("a move was handled before the roster was loaded")
+	default:
+	}
+	queue, err := ch.QueueDeclare("waiting", true, false, false, false, amqp.Table{"x-dead-letter-exchange": routing.ExchangePerilDLX})
+	if err != nil {
+		t.Fatalf("QueueDeclare: %v", err)
+	}
+	if queue.Messages != 1 {
+		t.Errorf("waiting holds %d messages, want the move requeued", queue.Messages)
+	}
+}
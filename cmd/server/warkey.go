@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// loadWarKey reads the key war results are signed with, a hex-encoded
+// ed25519 seed. If path does not exist a new key is made, and its public half
+// written to path.pub for clients to verify results with.
+func loadWarKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return createWarKey(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read war key: %v", err)
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s does not hold a hex-encoded ed25519 seed", path)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// createWarKey writes a new key to path. Servers started together by
+// multiserver.sh race to create it, so both halves are written to temporary
+// files first and only appear at path and path.pub once whole; a server that
+// loses the race uses the winner's key.
+func createWarKey(path string) (ed25519.PrivateKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate war key: %v", err)
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	seed := hex.EncodeToString(private.Seed()) + "\n"
+	if err := os.WriteFile(tmp, []byte(seed), 0600); err != nil {
+		return nil, fmt.Errorf("could not write war key: %v", err)
+	}
+	defer os.Remove(tmp)
+	tmpPub := fmt.Sprintf("%s.pub.%d.tmp", path, os.Getpid())
+	if err := os.WriteFile(tmpPub, []byte(hex.EncodeToString(public)+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("could not write war public key: %v", err)
+	}
+	defer os.Remove(tmpPub)
+
+	if err := os.Link(tmp, path); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return loadWarKey(path)
+		}
+		return nil, fmt.Errorf("could not write war key: %v", err)
+	}
+	// Only the server that created path gets here. Renaming replaces any
+	// public key left over from a key that has since been deleted.
+	if err := os.Rename(tmpPub, path+".pub"); err != nil {
+		return nil, fmt.Errorf("could not write war public key: %v", err)
+	}
+	fmt.Printf("Created war key %s; clients verify results with %s.pub\n", path, path)
+	return private, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadWarKeyCreatesBothHalves(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "war.key")
+	// A public key left over from a deleted key is replaced.
+	if err := os.WriteFile(path+".pub", []byte("stale\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	private, err := loadWarKey(path)
+	if err != nil {
+		t.Fatalf("loadWarKey: %v", err)
+	}
+	data, err := os.ReadFile(path + ".pub")
+	if err != nil {
+		t.Fatalf("reading public key: %v", err)
+	}
+	public, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || !ed25519.PublicKey(public).Equal(private.Public()) {
+		t.Errorf("%s.pub holds %q, not the public half of the new key", path, data)
+	}
+
+	again, err := loadWarKey(path)
+	if err != nil {
+		t.Fatalf("loadWarKey: %v", err)
+	}
+	if !again.Equal(private) {
+		t.Error("loading the key again returned a different key")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("directory holds %d files, want the key and its public half", len(entries))
+	}
+}
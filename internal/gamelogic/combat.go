@@ -0,0 +1,140 @@
+package gamelogic
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// CombatResolver settles a battle. It gets the battle with each side's units
+// and power filled in, and sets who won and which units died.
+type CombatResolver interface {
+	Fight(ranks map[UnitRank]Rank, attacker, defender string, battle *Battle)
+}
+
+// PowerResolver is the classic rule: the side with more power wins and the
+// other side loses every unit in the battle. In a draw both sides do.
+type PowerResolver struct{}
+
+func (PowerResolver) Fight(ranks map[UnitRank]Rank, attacker, defender string, battle *Battle) {
+	switch {
+	case battle.AttackerPower > battle.DefenderPower:
+		battle.Winner, battle.Loser = attacker, defender
+		battle.Casualties = unitRefs(defender, battle.DefenderUnits)
+	case battle.DefenderPower > battle.AttackerPower:
+		battle.Winner, battle.Loser = defender, attacker
+		battle.Casualties = unitRefs(attacker, battle.AttackerUnits)
+	default:
+		battle.Winner, battle.Loser = attacker, defender
+		battle.Draw = true
+		battle.Casualties = append(unitRefs(attacker, battle.AttackerUnits), unitRefs(defender, battle.DefenderUnits)...)
+	}
+}
+
+// RNG is where dice rolls come from. *rand.Rand satisfies it.
+type RNG interface {
+	Intn(n int) int
+}
+
+// DiceResolver fights battles in rounds of dice, like Risk. Each round the
+// attacker rolls a die for up to three of their units and the defender for
+// up to two. The highest dice are paired off, and whoever rolled lower in a
+// pair, the attacker on a tie, takes a hit. A unit dies after as many hits
+// as its rank's power, weakest units first. The battle goes on until one
+// side has no units left, so the winner usually loses some units too.
+type DiceResolver struct {
+	// AttackerModifier and DefenderModifier are added to every die the
+	// side rolls.
+	AttackerModifier int
+	DefenderModifier int
+
+	mu  sync.Mutex
+	rng RNG
+}
+
+// NewDiceResolver rolls dice from rng. Resolvers made with the same seeded
+// rng fight the same battles the same way.
+func NewDiceResolver(rng RNG, attackerModifier, defenderModifier int) *DiceResolver {
+	return &DiceResolver{
+		AttackerModifier: attackerModifier,
+		DefenderModifier: defenderModifier,
+		rng:              rng,
+	}
+}
+
+// NewSeededDiceResolver is a DiceResolver rolling from math/rand seeded with
+// seed.
+func NewSeededDiceResolver(seed int64, attackerModifier, defenderModifier int) *DiceResolver {
+	return NewDiceResolver(rand.New(rand.NewSource(seed)), attackerModifier, defenderModifier)
+}
+
+const (
+	attackerDice = 3
+	defenderDice = 2
+)
+
+func (d *DiceResolver) Fight(ranks map[UnitRank]Rank, attacker, defender string, battle *Battle) {
+	attackers := newFighters(ranks, battle.AttackerUnits)
+	defenders := newFighters(ranks, battle.DefenderUnits)
+
+	d.mu.Lock()
+	for len(attackers.alive) > 0 && len(defenders.alive) > 0 {
+		attackRolls := d.roll(min(attackerDice, len(attackers.alive)), d.AttackerModifier)
+		defendRolls := d.roll(min(defenderDice, len(defenders.alive)), d.DefenderModifier)
+		for i := 0; i < len(attackRolls) && i < len(defendRolls); i++ {
+			if attackRolls[i] > defendRolls[i] {
+				defenders.hit()
+			} else {
+				attackers.hit()
+			}
+		}
+	}
+	d.mu.Unlock()
+
+	if len(attackers.alive) > 0 {
+		battle.Winner, battle.Loser = attacker, defender
+	} else {
+		battle.Winner, battle.Loser = defender, attacker
+	}
+	battle.Casualties = append(unitRefs(attacker, attackers.dead), unitRefs(defender, defenders.dead)...)
+}
+
+// roll rolls n dice, highest first. It must be called with d.mu held.
+func (d *DiceResolver) roll(n, modifier int) []int {
+	rolls := make([]int, n)
+	for i := range rolls {
+		rolls[i] = d.rng.Intn(6) + 1 + modifier
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(rolls)))
+	return rolls
+}
+
+// fighters are one side of a battle, weakest unit in front.
+type fighters struct {
+	alive []Unit
+	dead  []Unit
+	// health is how many more hits each unit can take.
+	health []int
+}
+
+func newFighters(ranks map[UnitRank]Rank, units []Unit) *fighters {
+	f := &fighters{alive: append([]Unit{}, units...), dead: []Unit{}}
+	sort.SliceStable(f.alive, func(i, j int) bool {
+		return ranks[f.alive[i].Rank].Power < ranks[f.alive[j].Rank].Power
+	})
+	for _, unit := range f.alive {
+		f.health = append(f.health, max(ranks[unit.Rank].Power, 1))
+	}
+	return f
+}
+
+// hit wounds the front unit, killing it if that was its last hit.
+func (f *fighters) hit() {
+	f.health[0]--
+	if f.health[0] > 0 {
+		return
+	}
+	f.dead = append(f.dead, f.alive[0])
+	f.alive = f.alive[1:]
+	f.health = f.health[1:]
+}
@@ -0,0 +1,105 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newBattle(ranks map[UnitRank]Rank, attackerUnits, defenderUnits []Unit) *Battle {
+	battle := &Battle{
+		Location:      "europe",
+		AttackerUnits: attackerUnits,
+		DefenderUnits: defenderUnits,
+		Casualties:    []UnitRef{},
+	}
+	for _, unit := range attackerUnits {
+		battle.AttackerPower += ranks[unit.Rank].Power
+	}
+	for _, unit := range defenderUnits {
+		battle.DefenderPower += ranks[unit.Rank].Power
+	}
+	return battle
+}
+
+func TestPowerResolver(t *testing.T) {
+	ranks := DefaultScenario().Ranks
+	infantry := Unit{ID: 1, Rank: RankInfantry, Location: "europe"}
+	cavalry := Unit{ID: 2, Rank: RankCavalry, Location: "europe"}
+	artillery := Unit{ID: 3, Rank: RankArtillery, Location: "europe"}
+	tests := []struct {
+		name       string
+		attacker   []Unit
+		defender   []Unit
+		winner     string
+		draw       bool
+		casualties []UnitRef
+	}{
+		{
+			name:       "attacker stronger",
+			attacker:   []Unit{artillery},
+			defender:   []Unit{infantry, cavalry},
+			winner:     "alice",
+			casualties: []UnitRef{"bob/1", "bob/2"},
+		},
+		{
+			name:       "defender stronger",
+			attacker:   []Unit{infantry},
+			defender:   []Unit{cavalry},
+			winner:     "bob",
+			casualties: []UnitRef{"alice/1"},
+		},
+		{
+			name:       "draw kills both sides",
+			attacker:   []Unit{cavalry},
+			defender:   []Unit{cavalry},
+			winner:     "alice",
+			draw:       true,
+			casualties: []UnitRef{"alice/2", "bob/2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			battle := newBattle(ranks, tt.attacker, tt.defender)
+			PowerResolver{}.Fight(ranks, "alice", "bob", battle)
+			if battle.Winner != tt.winner || battle.Draw != tt.draw {
+				t.Errorf("winner = %s, draw = %v, want %s, %v", battle.Winner, battle.Draw, tt.winner, tt.draw)
+			}
+			if !reflect.DeepEqual(battle.Casualties, tt.casualties) {
+				t.Errorf("casualties = %v, want %v", battle.Casualties, tt.casualties)
+			}
+		})
+	}
+}
+
+func TestDiceResolverSameSeedSameOutcome(t *testing.T) {
+	ranks := DefaultScenario().Ranks
+	attacker := []Unit{
+		{ID: 1, Rank: RankInfantry, Location: "europe"},
+		{ID: 2, Rank: RankCavalry, Location: "europe"},
+		{ID: 3, Rank: RankArtillery, Location: "europe"},
+	}
+	defender := []Unit{
+		{ID: 1, Rank: RankCavalry, Location: "europe"},
+		{ID: 2, Rank: RankCavalry, Location: "europe"},
+	}
+	fight := func(seed int64) []Battle {
+		resolver := NewSeededDiceResolver(seed, 0, 0)
+		battles := []Battle{}
+		for i := 0; i < 5; i++ {
+			battle := newBattle(ranks, attacker, defender)
+			resolver.Fight(ranks, "alice", "bob", battle)
+			battles = append(battles, *battle)
+		}
+		return battles
+	}
+
+	first, second := fight(42), fight(42)
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("the same seed fought differently:\n%+v\n%+v", first, second)
+	}
+	for _, battle := range first {
+		if len(battle.Casualties) == 0 {
+			t.Errorf("battle ended with no casualties: %+v", battle)
+		}
+	}
+}
@@ -25,9 +25,21 @@ type ArmyMove struct {
 	ToLocation Location
 }
 
+// UnitSpawn tells the server about a unit a player spawned, so it can keep
+// its own record of every player's units.
+type UnitSpawn struct {
+	Username string
+	Unit     Unit
+}
+
+// RecognitionOfWar declares a war to the server. The server resolves it
+// against its own record of both players and fights on every front it finds
+// there. Locations are the fronts the declaring player saw; the server waits
+// until its record shows each of them before fighting.
 type RecognitionOfWar struct {
-	Attacker Player
-	Defender Player
+	Attacker  Player
+	Defender  Player
+	Locations []Location
 }
 
 type Location string
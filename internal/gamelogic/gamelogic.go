@@ -11,13 +11,19 @@ import (
 
 func PrintClientHelp() {
 	fmt.Println("Possible commands:")
-	fmt.Println("* move <location> <unitID> <unitID> <unitID>...")
+	fmt.Println("* move <location>[,<location>...] <unitID> <unitID> <unitID>...")
 	fmt.Println("    example:")
 	fmt.Println("    move asia 1")
+	fmt.Println("    move asia,australia 1 2")
 	fmt.Println("* spawn <location> <rank>")
 	fmt.Println("    example:")
 	fmt.Println("    spawn europe infantry")
 	fmt.Println("* status")
+	fmt.Println("* map")
+	fmt.Println("* paused")
+	fmt.Println("* units <username>")
+	fmt.Println("    example:")
+	fmt.Println("    units washington")
 	fmt.Println("* spam <n>")
 	fmt.Println("    example:")
 	fmt.Println("    spam 5")
@@ -25,6 +31,18 @@ func PrintClientHelp() {
 	fmt.Println("* help")
 }
 
+// ValidateUsername rejects usernames that would break the routing keys and
+// unit refs they are written into.
+func ValidateUsername(username string) error {
+	if username == "" {
+		return errors.New("you must enter a username")
+	}
+	if i := strings.IndexAny(username, "/.*#"); i >= 0 {
+		return fmt.Errorf("usernames can not contain %q", username[i])
+	}
+	return nil
+}
+
 func ClientWelcome() (string, error) {
 	fmt.Println("Welcome to the Peril client!")
 	fmt.Println("Please enter your username:")
@@ -33,6 +51,9 @@ func ClientWelcome() (string, error) {
 		return "", errors.New("you must enter a username. goodbye")
 	}
 	username := words[0]
+	if err := ValidateUsername(username); err != nil {
+		return "", fmt.Errorf("%v. goodbye", err)
+	}
 	fmt.Printf("Welcome, %s!\n", username)
 	PrintClientHelp()
 	return username, nil
@@ -42,6 +63,7 @@ func PrintServerHelp() {
 	fmt.Println("Possible commands:")
 	fmt.Println("* pause")
 	fmt.Println("* resume")
+	fmt.Println("* topology")
 	fmt.Println("* quit")
 	fmt.Println("* help")
 }
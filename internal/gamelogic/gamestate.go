@@ -1,36 +1,74 @@
 package gamelogic
 
 import (
+	"fmt"
 	"sync"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
 )
 
 type GameState struct {
-	Player Player
-	Paused bool
-	mu     *sync.RWMutex
+	Player       Player
+	Paused       bool
+	PauseVersion uint64
+	// LastUnitID is the last unit ID handed out. IDs only ever grow, so a
+	// unit killed in a war never has its ID reused; save it along with
+	// the units.
+	LastUnitID int
+	scenario   *Scenario
+	// spent is the cost of every unit spawned, held against the
+	// scenario's spawn budget.
+	spent int
+	mu    *sync.RWMutex
 }
 
+// NewGameState starts a game of the default scenario.
 func NewGameState(username string) *GameState {
+	return DefaultScenario().NewGameState(username)
+}
+
+func newGameState(username string, scenario *Scenario) *GameState {
 	return &GameState{
 		Player: Player{
 			Username: username,
 			Units:    map[int]Unit{},
 		},
-		Paused: false,
-		mu:     &sync.RWMutex{},
+		Paused:   false,
+		scenario: scenario,
+		mu:       &sync.RWMutex{},
 	}
 }
 
-func (gs *GameState) resumeGame() {
+func (gs *GameState) WorldMap() *WorldMap {
+	return gs.scenario.Map
+}
+
+// spend charges cost against the spawn budget, unless it would go over.
+func (gs *GameState) spend(cost int) error {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
-	gs.Paused = false
+	budget := gs.scenario.SpawnBudget
+	if budget > 0 && gs.spent+cost > budget {
+		return fmt.Errorf("that costs %d and only %d of the spawn budget of %d is left", cost, budget-gs.spent, budget)
+	}
+	gs.spent += cost
+	return nil
 }
 
-func (gs *GameState) pauseGame() {
+// applyPlayingState switches to ps unless it is older than the state the
+// game is already in.
+func (gs *GameState) applyPlayingState(ps routing.PlayingState) bool {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
-	gs.Paused = true
+	current := routing.PlayingState{IsPaused: gs.Paused, Version: gs.PauseVersion}
+	if !ps.Supersedes(current) {
+		return false
+	}
+	gs.Paused = ps.IsPaused
+	if ps.Version != 0 {
+		gs.PauseVersion = ps.Version
+	}
+	return true
 }
 
 func (gs *GameState) isPaused() bool {
@@ -43,6 +81,23 @@ func (gs *GameState) addUnit(u Unit) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
 	gs.Player.Units[u.ID] = u
+	if u.ID > gs.LastUnitID {
+		gs.LastUnitID = u.ID
+	}
+}
+
+func (gs *GameState) lastUnitID() int {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.LastUnitID
+}
+
+// nextUnitID hands out the ID for a new unit.
+func (gs *GameState) nextUnitID() int {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.LastUnitID++
+	return gs.LastUnitID
 }
 
 func (gs *GameState) removeUnitsInLocation(loc Location) {
@@ -55,6 +110,14 @@ func (gs *GameState) removeUnitsInLocation(loc Location) {
 	}
 }
 
+func (gs *GameState) removeUnits(ids []int) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	for _, id := range ids {
+		delete(gs.Player.Units, id)
+	}
+}
+
 func (gs *GameState) UpdateUnit(u Unit) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
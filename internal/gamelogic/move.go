@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 type MoveOutcome int
@@ -29,38 +30,59 @@ func (gs *GameState) HandleMove(move ArmyMove) MoveOutcome {
 		return MoveOutcomeSamePlayer
 	}
 
-	overlappingLocation := getOverlappingLocation(player, move.Player)
-	if overlappingLocation != "" {
-		fmt.Printf("You have units in %s! You are at war with %s!\n", overlappingLocation, move.Player.Username)
+	overlappingLocations := getOverlappingLocations(player, move.Player)
+	if len(overlappingLocations) > 0 {
+		fmt.Printf("You have units in %v! You are at war with %s!\n", overlappingLocations, move.Player.Username)
 		return MoveOutcomeMakeWar
 	}
 	fmt.Printf("You are safe from %s's units.\n", move.Player.Username)
 	return MoveOutComeSafe
 }
 
-func getOverlappingLocation(p1 Player, p2 Player) Location {
+// OverlappingLocations lists, in order, every location where both players
+// have units.
+func OverlappingLocations(p1 Player, p2 Player) []Location {
+	return getOverlappingLocations(p1, p2)
+}
+
+func getOverlappingLocations(p1 Player, p2 Player) []Location {
+	occupied := map[Location]bool{}
 	for _, u1 := range p1.Units {
-		for _, u2 := range p2.Units {
-			if u1.Location == u2.Location {
-				return u1.Location
-			}
+		occupied[u1.Location] = true
+	}
+	shared := map[Location]bool{}
+	for _, u2 := range p2.Units {
+		if occupied[u2.Location] {
+			shared[u2.Location] = true
 		}
 	}
-	return ""
+	locations := []Location{}
+	for loc := range shared {
+		locations = append(locations, loc)
+	}
+	sortLocations(locations)
+	return locations
 }
 
+// CommandMove moves units to a location, either given alone, when it must be
+// adjacent to each unit, or as the last stop of a comma-separated route.
 func (gs *GameState) CommandMove(words []string) (ArmyMove, error) {
 	if gs.isPaused() {
 		return ArmyMove{}, errors.New("the game is paused, you can not move units")
 	}
 	if len(words) < 3 {
-		return ArmyMove{}, errors.New("usage: move <location> <unitID> <unitID> <unitID> etc")
+		return ArmyMove{}, errors.New("usage: move <location>[,<location>...] <unitID> <unitID> <unitID> etc")
 	}
-	newLocation := Location(words[1])
-	locations := getAllLocations()
-	if _, ok := locations[newLocation]; !ok {
-		return ArmyMove{}, fmt.Errorf("error: %s is not a valid location", newLocation)
+	route := []Location{}
+	for _, name := range strings.Split(words[1], ",") {
+		loc := Location(name)
+		if !gs.WorldMap().HasTerritory(loc) {
+			return ArmyMove{}, fmt.Errorf("error: %s is not a valid location", loc)
+		}
+		route = append(route, loc)
 	}
+	newLocation := route[len(route)-1]
+
 	unitIDs := []int{}
 	for _, word := range words[2:] {
 		id := word
@@ -77,10 +99,15 @@ func (gs *GameState) CommandMove(words []string) (ArmyMove, error) {
 		if !ok {
 			return ArmyMove{}, fmt.Errorf("error: unit with ID %v not found", unitID)
 		}
+		if err := gs.checkRoute(unit, route); err != nil {
+			return ArmyMove{}, fmt.Errorf("error: unit %v can not move: %v", unitID, err)
+		}
 		unit.Location = newLocation
-		gs.UpdateUnit(unit)
 		newUnits = append(newUnits, unit)
 	}
+	for _, unit := range newUnits {
+		gs.UpdateUnit(unit)
+	}
 
 	mv := ArmyMove{
 		ToLocation: newLocation,
@@ -90,3 +117,37 @@ func (gs *GameState) CommandMove(words []string) (ArmyMove, error) {
 	fmt.Printf("Moved %v units to %s\n", len(mv.Units), mv.ToLocation)
 	return mv, nil
 }
+
+// checkRoute checks unit can follow route from where it is in one move, and
+// suggests the shortest route when it can not.
+func (gs *GameState) checkRoute(unit Unit, route []Location) error {
+	if route[0] != unit.Location {
+		route = append([]Location{unit.Location}, route...)
+	}
+	dest := route[len(route)-1]
+	cost, err := gs.WorldMap().RouteCost(route)
+	if err == nil && cost <= gs.WorldMap().MaxMoveCost {
+		return nil
+	}
+	if err == nil {
+		err = fmt.Errorf("the route costs %d, more than the %d a move allows", cost, gs.WorldMap().MaxMoveCost)
+	}
+	if shortest, cost, ok := gs.WorldMap().ShortestPath(unit.Location, dest); ok && cost <= gs.WorldMap().MaxMoveCost {
+		return fmt.Errorf("%v; try %s", err, FormatRoute(shortest[1:]))
+	}
+	return fmt.Errorf("%v; %s is out of reach from %s", err, dest, unit.Location)
+}
+
+// CommandMap prints the territories and what each is adjacent to.
+func (gs *GameState) CommandMap() {
+	m := gs.WorldMap()
+	fmt.Printf("A move may cost at most %d.\n", m.MaxMoveCost)
+	for _, loc := range m.Territories() {
+		neighbors := []string{}
+		for _, next := range m.Neighbors(loc) {
+			cost, _ := m.Cost(loc, next)
+			neighbors = append(neighbors, fmt.Sprintf("%s (%d)", next, cost))
+		}
+		fmt.Printf("* %s: %s\n", loc, strings.Join(neighbors, ", "))
+	}
+}
@@ -0,0 +1,51 @@
+package gamelogic
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCommandMove(t *testing.T) {
+	tests := []struct {
+		name  string
+		route string
+		// to is where the unit ends up, or empty when the move is rejected
+		// with an error containing err.
+		to  Location
+		err string
+	}{
+		{name: "adjacent", route: "europe", to: "europe"},
+		{name: "multi-hop route", route: "europe,africa", to: "africa"},
+		{name: "route starting where the unit is", route: "americas,asia,australia", to: "australia"},
+		{name: "not adjacent", route: "africa", err: "africa is not adjacent to americas; try asia,africa"},
+		{name: "route with a gap", route: "europe,australia", err: "australia is not adjacent to europe"},
+		{name: "route over the move cost", route: "europe,asia,australia", err: "the route costs 4, more than the 3 a move allows; try asia,australia"},
+		{name: "not a territory", route: "europe,atlantis", err: "atlantis is not a valid location"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := NewGameState("alice")
+			gs.addUnit(Unit{ID: 1, Rank: RankInfantry, Location: "americas"})
+
+			move, err := gs.CommandMove([]string{"move", tt.route, "1"})
+			if tt.to == "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("CommandMove error = %v, want one containing %q", err, tt.err)
+				}
+				if unit, _ := gs.GetUnit(1); unit.Location != "americas" {
+					t.Errorf("rejected move left the unit in %s", unit.Location)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CommandMove: %v", err)
+			}
+			if move.ToLocation != tt.to || len(move.Units) != 1 || move.Units[0].Location != tt.to {
+				t.Errorf("move = %+v, want unit 1 moved to %s", move, tt.to)
+			}
+			if unit, _ := gs.GetUnit(1); unit.Location != tt.to {
+				t.Errorf("unit is in %s after the move, want %s", unit.Location, tt.to)
+			}
+		})
+	}
+}
@@ -6,14 +6,18 @@ import (
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
 )
 
-func (gs *GameState) HandlePause(ps routing.PlayingState) {
+// HandlePause applies ps and reports whether it did. States older than the
+// current one, such as a broadcast delayed past a newer one, are ignored.
+func (gs *GameState) HandlePause(ps routing.PlayingState) bool {
+	if !gs.applyPlayingState(ps) {
+		return false
+	}
 	defer fmt.Println("------------------------")
 	fmt.Println()
 	if ps.IsPaused {
 		fmt.Println("==== Pause Detected ====")
-		gs.pauseGame()
 	} else {
 		fmt.Println("==== Resume Detected ====")
-		gs.resumeGame()
 	}
+	return true
 }
@@ -0,0 +1,213 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Roster is the server's own record of every player's units, built from the
+// spawns, moves and war results it sees. Wars are resolved against it rather
+// than against what the players claim to have.
+type Roster struct {
+	scenario *Scenario
+
+	mu      sync.Mutex
+	players map[string]*GameState
+}
+
+// NewRoster starts a record of the default scenario.
+func NewRoster() *Roster {
+	return DefaultScenario().NewRoster()
+}
+
+func (r *Roster) player(username string) *GameState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	gs, ok := r.players[username]
+	if !ok {
+		gs = newGameState(username, r.scenario)
+		r.players[username] = gs
+	}
+	return gs
+}
+
+// ApplySpawn records a spawned unit, after checking it is one the player
+// could have spawned.
+func (r *Roster) ApplySpawn(spawn UnitSpawn) error {
+	if err := ValidateUsername(spawn.Username); err != nil {
+		return err
+	}
+	if !r.scenario.Map.HasTerritory(spawn.Unit.Location) {
+		return fmt.Errorf("%s is not a valid location", spawn.Unit.Location)
+	}
+	rank, ok := r.scenario.Ranks[spawn.Unit.Rank]
+	if !ok {
+		return fmt.Errorf("%s is not a valid unit", spawn.Unit.Rank)
+	}
+	gs := r.player(spawn.Username)
+	if _, ok := gs.GetUnit(spawn.Unit.ID); ok {
+		return fmt.Errorf("%s already has a unit with ID %v", spawn.Username, spawn.Unit.ID)
+	}
+	// IDs only ever grow, so one at or below the last is a reused one.
+	if spawn.Unit.ID <= gs.lastUnitID() {
+		return fmt.Errorf("%s already used unit ID %v", spawn.Username, spawn.Unit.ID)
+	}
+	if err := gs.spend(rank.Cost); err != nil {
+		return err
+	}
+	gs.addUnit(spawn.Unit)
+	return nil
+}
+
+// ApplyMove moves the player's recorded units. Only their locations are
+// taken from move; a move naming a unit the player does not have, or one
+// that can not reach ToLocation in one move, is rejected as a whole.
+func (r *Roster) ApplyMove(move ArmyMove) error {
+	if !r.scenario.Map.HasTerritory(move.ToLocation) {
+		return fmt.Errorf("%s is not a valid location", move.ToLocation)
+	}
+	gs := r.player(move.Player.Username)
+	moved := []Unit{}
+	for _, claimed := range move.Units {
+		unit, ok := gs.GetUnit(claimed.ID)
+		if !ok {
+			return fmt.Errorf("%s has no unit with ID %v", move.Player.Username, claimed.ID)
+		}
+		_, cost, ok := r.scenario.Map.ShortestPath(unit.Location, move.ToLocation)
+		if !ok || cost > r.scenario.Map.MaxMoveCost {
+			return fmt.Errorf("unit %v can not reach %s from %s in one move", unit.ID, move.ToLocation, unit.Location)
+		}
+		unit.Location = move.ToLocation
+		moved = append(moved, unit)
+	}
+	for _, unit := range moved {
+		gs.UpdateUnit(unit)
+	}
+	return nil
+}
+
+// ApplyWarReport removes the units each player lost in a war.
+func (r *Roster) ApplyWarReport(report WarReport) {
+	for _, battle := range report.Battles {
+		for _, ref := range battle.Casualties {
+			username, id, err := ref.Parse()
+			if err != nil {
+				continue
+			}
+			r.player(username).removeUnits([]int{id})
+		}
+	}
+}
+
+// ResolveWar fights the war rw declares between the players as the roster
+// records them.
+func (r *Roster) ResolveWar(rw RecognitionOfWar) WarReport {
+	attacker := r.player(rw.Attacker.Username).GetPlayerSnap()
+	defender := r.player(rw.Defender.Username).GetPlayerSnap()
+	return r.scenario.ResolveWar(attacker, defender)
+}
+
+// Victor reports the player who has won the game, if any.
+func (r *Roster) Victor() (string, bool) {
+	r.mu.Lock()
+	players := []Player{}
+	for _, gs := range r.players {
+		players = append(players, gs.GetPlayerSnap())
+	}
+	r.mu.Unlock()
+	return r.scenario.Victor(players)
+}
+
+// RosterEntry is one player's record in a roster snapshot.
+type RosterEntry struct {
+	Player     Player
+	LastUnitID int
+	Spent      int
+}
+
+// Snapshot copies the record of every player, for a server that starts
+// after the game has to restore.
+func (r *Roster) Snapshot() []RosterEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entries := []RosterEntry{}
+	for _, gs := range r.players {
+		gs.mu.RLock()
+		lastUnitID, spent := gs.LastUnitID, gs.spent
+		gs.mu.RUnlock()
+		entries = append(entries, RosterEntry{
+			Player:     gs.GetPlayerSnap(),
+			LastUnitID: lastUnitID,
+			Spent:      spent,
+		})
+	}
+	return entries
+}
+
+// Restore replaces the record of every player with a snapshot taken from
+// another roster.
+func (r *Roster) Restore(entries []RosterEntry) {
+	players := map[string]*GameState{}
+	for _, entry := range entries {
+		gs := newGameState(entry.Player.Username, r.scenario)
+		for id, unit := range entry.Player.Units {
+			gs.Player.Units[id] = unit
+		}
+		gs.LastUnitID = entry.LastUnitID
+		gs.spent = entry.Spent
+		players[entry.Player.Username] = gs
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.players = players
+}
+
+// ErrPlayerKnown is returned by RestorePlayer for a player the roster
+// already has a record of.
+var ErrPlayerKnown = errors.New("the roster already has a record of this player")
+
+// Knows reports whether the roster has a record of username.
+func (r *Roster) Knows(username string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.players[username]
+	return ok
+}
+
+// RestorePlayer records the units a player the roster has never seen claims
+// to have, after checking each is one they could have and that together
+// they fit the spawn budget. It is only for when no other roster is left to
+// restore from; a player the roster knows keeps the record it built.
+func (r *Roster) RestorePlayer(p Player) error {
+	if err := ValidateUsername(p.Username); err != nil {
+		return err
+	}
+	gs := newGameState(p.Username, r.scenario)
+	for _, unit := range p.Units {
+		if unit.ID < 1 {
+			return fmt.Errorf("%v is not a valid unit ID", unit.ID)
+		}
+		if !r.scenario.Map.HasTerritory(unit.Location) {
+			return fmt.Errorf("%s is not a valid location", unit.Location)
+		}
+		rank, ok := r.scenario.Ranks[unit.Rank]
+		if !ok {
+			return fmt.Errorf("%s is not a valid unit", unit.Rank)
+		}
+		gs.spent += rank.Cost
+		// addUnit moves LastUnitID up to the highest ID restored, so later
+		// spawns can not reuse any of them.
+		gs.addUnit(unit)
+	}
+	if budget := r.scenario.SpawnBudget; budget > 0 && gs.spent > budget {
+		return fmt.Errorf("%s claims units costing %d, over the spawn budget of %d", p.Username, gs.spent, budget)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.players[p.Username]; ok {
+		return ErrPlayerKnown
+	}
+	r.players[p.Username] = gs
+	return nil
+}
@@ -0,0 +1,132 @@
+package gamelogic
+
+import (
+	"errors"
+	"testing"
+)
+
+func spawn(t *testing.T, r *Roster, username string, id int, rank UnitRank, loc Location) {
+	t.Helper()
+	if err := r.ApplySpawn(UnitSpawn{Username: username, Unit: Unit{ID: id, Rank: rank, Location: loc}}); err != nil {
+		t.Fatalf("spawning %s's unit %d: %v", username, id, err)
+	}
+}
+
+func units(r *Roster, username string) map[int]Unit {
+	return r.player(username).GetPlayerSnap().Units
+}
+
+func TestRosterSpawnsAfterWarLosses(t *testing.T) {
+	r := NewRoster()
+	// Both players number their units from 1.
+	spawn(t, r, "alice", 1, RankArtillery, "europe")
+	spawn(t, r, "bob", 1, RankInfantry, "europe")
+	spawn(t, r, "bob", 2, RankInfantry, "asia")
+
+	report := r.ResolveWar(RecognitionOfWar{Attacker: Player{Username: "alice"}, Defender: Player{Username: "bob"}})
+	r.ApplyWarReport(report)
+	if _, ok := units(r, "alice")[1]; !ok {
+		t.Fatal("alice lost unit 1 when bob's unit 1 was killed")
+	}
+	if _, ok := units(r, "bob")[1]; ok {
+		t.Fatal("bob still has unit 1 after losing it")
+	}
+
+	// bob's next unit takes the next ID; it must not be confused with
+	// alice's unit of the same number, nor with the one bob lost.
+	spawn(t, r, "bob", 3, RankCavalry, "europe")
+	if err := r.ApplySpawn(UnitSpawn{Username: "bob", Unit: Unit{ID: 2, Rank: RankInfantry, Location: "europe"}}); err == nil {
+		t.Error("roster accepted a second unit 2 for bob")
+	}
+	if got := len(units(r, "bob")); got != 2 {
+		t.Errorf("bob has %d units, want 2", got)
+	}
+	if got := units(r, "alice")[1].Rank; got != RankArtillery {
+		t.Errorf("alice's unit 1 is %s, want artillery", got)
+	}
+
+	// A second war in europe kills only bob's new cavalry.
+	report = r.ResolveWar(RecognitionOfWar{Attacker: Player{Username: "alice"}, Defender: Player{Username: "bob"}})
+	for _, battle := range report.Battles {
+		for _, ref := range battle.Casualties {
+			if ref != NewUnitRef("bob", 3) {
+				t.Errorf("casualty %s, want only bob/3", ref)
+			}
+		}
+	}
+	r.ApplyWarReport(report)
+	if _, ok := units(r, "alice")[1]; !ok {
+		t.Error("alice lost unit 1 in the second war")
+	}
+	if _, ok := units(r, "bob")[2]; !ok {
+		t.Error("bob lost unit 2, which was not at war")
+	}
+}
+
+func TestRosterRejectsInvalidUsernames(t *testing.T) {
+	r := NewRoster()
+	err := r.ApplySpawn(UnitSpawn{Username: "alice/1", Unit: Unit{ID: 1, Rank: RankInfantry, Location: "europe"}})
+	if err == nil {
+		t.Error("roster accepted a spawn from alice/1")
+	}
+}
+
+func TestRosterRejectsReusedUnitIDs(t *testing.T) {
+	r := NewRoster()
+	spawn(t, r, "alice", 1, RankInfantry, "europe")
+	spawn(t, r, "alice", 2, RankInfantry, "europe")
+	r.ApplyWarReport(WarReport{Battles: []Battle{{Casualties: []UnitRef{NewUnitRef("alice", 1)}}}})
+	err := r.ApplySpawn(UnitSpawn{Username: "alice", Unit: Unit{ID: 1, Rank: RankInfantry, Location: "asia"}})
+	if err == nil {
+		t.Error("roster accepted the ID of a unit alice lost")
+	}
+}
+
+func TestRosterRestorePlayer(t *testing.T) {
+	scenario := DefaultScenario()
+	scenario.SpawnBudget = 7
+	cavalry := func(id int) Unit { return Unit{ID: id, Rank: RankCavalry, Location: "asia"} }
+
+	t.Run("unseen player", func(t *testing.T) {
+		r := scenario.NewRoster()
+		err := r.RestorePlayer(Player{Username: "alice", Units: map[int]Unit{2: cavalry(2), 5: cavalry(5)}})
+		if err != nil {
+			t.Fatalf("RestorePlayer: %v", err)
+		}
+		if got := len(units(r, "alice")); got != 2 {
+			t.Errorf("alice has %d units, want 2", got)
+		}
+		// Later spawns start above the highest restored ID, and the
+		// restored units count against the budget.
+		if err := r.ApplySpawn(UnitSpawn{Username: "alice", Unit: Unit{ID: 4, Rank: RankInfantry, Location: "asia"}}); err == nil {
+			t.Error("roster accepted an ID below the highest restored one")
+		}
+		spawn(t, r, "alice", 6, RankInfantry, "asia")
+		if err := r.ApplySpawn(UnitSpawn{Username: "alice", Unit: Unit{ID: 7, Rank: RankInfantry, Location: "asia"}}); err == nil {
+			t.Error("roster accepted a spawn over the budget")
+		}
+	})
+
+	t.Run("known player", func(t *testing.T) {
+		r := scenario.NewRoster()
+		spawn(t, r, "alice", 1, RankInfantry, "europe")
+		err := r.RestorePlayer(Player{Username: "alice", Units: map[int]Unit{2: cavalry(2)}})
+		if !errors.Is(err, ErrPlayerKnown) {
+			t.Errorf("RestorePlayer returned %v, want ErrPlayerKnown", err)
+		}
+		if got := units(r, "alice"); len(got) != 1 || got[1].Rank != RankInfantry {
+			t.Errorf("alice's record was replaced with %v", got)
+		}
+	})
+
+	t.Run("over budget", func(t *testing.T) {
+		r := scenario.NewRoster()
+		err := r.RestorePlayer(Player{Username: "alice", Units: map[int]Unit{1: cavalry(1), 2: cavalry(2), 3: cavalry(3)}})
+		if err == nil {
+			t.Error("roster accepted claimed units costing 9 against a budget of 7")
+		}
+		if r.Knows("alice") {
+			t.Error("a rejected claim left a record of alice")
+		}
+	})
+}
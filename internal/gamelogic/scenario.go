@@ -0,0 +1,117 @@
+package gamelogic
+
+import "sort"
+
+// Rank is a kind of unit: how much it counts for in battle and what it
+// costs to spawn.
+type Rank struct {
+	Name  UnitRank
+	Power int
+	Cost  int
+}
+
+// Victory is how a game is won. Zero values turn a condition off.
+type Victory struct {
+	// Territories wins the game for the first player to hold this many
+	// territories, holding one meaning having units there.
+	Territories int
+	// LastStanding wins the game for the only player left with units.
+	LastStanding bool
+}
+
+// Scenario is everything a game is played with: the map, the ranks units can
+// have, the units players start with and how the game is won. The server
+// and every client must use the same one.
+type Scenario struct {
+	Name  string
+	Map   *WorldMap
+	Ranks map[UnitRank]Rank
+	// SpawnBudget caps the total cost of the units a player may spawn.
+	// Zero means no cap.
+	SpawnBudget   int
+	StartingUnits map[string][]Unit
+	Victory       Victory
+	// Combat settles battles. Nil means PowerResolver.
+	Combat CombatResolver
+}
+
+// DefaultScenario is the game as it is played without a scenario file.
+func DefaultScenario() *Scenario {
+	return &Scenario{
+		Name: "default",
+		Map:  DefaultWorldMap(),
+		Ranks: map[UnitRank]Rank{
+			RankInfantry:  {Name: RankInfantry, Power: 1, Cost: 1},
+			RankCavalry:   {Name: RankCavalry, Power: 5, Cost: 3},
+			RankArtillery: {Name: RankArtillery, Power: 10, Cost: 5},
+		},
+		StartingUnits: map[string][]Unit{},
+	}
+}
+
+// NewGameState starts username's game with their starting units.
+func (s *Scenario) NewGameState(username string) *GameState {
+	gs := newGameState(username, s)
+	for _, unit := range s.StartingUnits[username] {
+		gs.addUnit(unit)
+	}
+	return gs
+}
+
+// NewRoster starts the server's record with every player's starting units.
+func (s *Scenario) NewRoster() *Roster {
+	r := &Roster{
+		scenario: s,
+		players:  map[string]*GameState{},
+	}
+	for username := range s.StartingUnits {
+		r.players[username] = s.NewGameState(username)
+	}
+	return r
+}
+
+// Victor reports the player who has won, if any, given every player's
+// units.
+func (s *Scenario) Victor(players []Player) (string, bool) {
+	// Sorted so ties go the same way everywhere, on a copy of the caller's.
+	players = append([]Player(nil), players...)
+	sort.Slice(players, func(i, j int) bool { return players[i].Username < players[j].Username })
+	if s.Victory.Territories > 0 {
+		for _, p := range players {
+			held := map[Location]bool{}
+			for _, unit := range p.Units {
+				held[unit.Location] = true
+			}
+			if len(held) >= s.Victory.Territories {
+				return p.Username, true
+			}
+		}
+	}
+	if s.Victory.LastStanding && len(players) > 1 {
+		standing := []string{}
+		for _, p := range players {
+			if len(p.Units) > 0 {
+				standing = append(standing, p.Username)
+			}
+		}
+		if len(standing) == 1 {
+			return standing[0], true
+		}
+	}
+	return "", false
+}
+
+func (s *Scenario) combat() CombatResolver {
+	if s.Combat == nil {
+		return PowerResolver{}
+	}
+	return s.Combat
+}
+
+func (s *Scenario) power(units []Unit) int {
+	power := 0
+	for _, unit := range units {
+		power += s.Ranks[unit.Rank].Power
+	}
+	return power
+}
@@ -0,0 +1,375 @@
+package gamelogic
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ScenarioError is a problem with a scenario file. Line is 0 when the
+// problem is with the file as a whole.
+type ScenarioError struct {
+	Path string
+	Line int
+	Msg  string
+}
+
+func (e *ScenarioError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.Path, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Msg)
+}
+
+// LoadScenario reads a scenario from a .json, .yaml or .yml file. Every
+// problem found is reported, each as a ScenarioError.
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read scenario: %v", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		// JSON is read as YAML, which does not allow tabs for indentation.
+		// Valid JSON has no raw tabs inside strings, so they can all go.
+		data = bytes.ReplaceAll(data, []byte("\t"), []byte(" "))
+	case ".yaml", ".yml":
+	default:
+		return nil, &ScenarioError{Path: path, Msg: "scenario files must be .json, .yaml or .yml"}
+	}
+	return ParseScenario(path, data)
+}
+
+// ParseScenario reads a scenario in YAML, or JSON. path is only used in
+// errors.
+func ParseScenario(path string, data []byte) (*Scenario, error) {
+	var file scenarioFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, decodeError(path, err)
+	}
+	return file.build(path)
+}
+
+type scenarioFile struct {
+	lines map[string]int
+
+	Name        string          `yaml:"name"`
+	MaxMoveCost *int            `yaml:"max_move_cost"`
+	SpawnBudget int             `yaml:"spawn_budget"`
+	Ranks       []rankSpec      `yaml:"ranks"`
+	Territories []territorySpec `yaml:"territories"`
+	Edges       []edgeSpec      `yaml:"edges"`
+	Players     []playerSpec    `yaml:"players"`
+	Victory     victorySpec     `yaml:"victory"`
+	Combat      combatSpec      `yaml:"combat"`
+}
+
+type rankSpec struct {
+	line  int
+	Name  string `yaml:"name"`
+	Power int    `yaml:"power"`
+	Cost  int    `yaml:"cost"`
+}
+
+type territorySpec struct {
+	line int
+	name string
+}
+
+type edgeSpec struct {
+	line int
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+	Cost int    `yaml:"cost"`
+}
+
+type playerSpec struct {
+	line     int
+	Username string     `yaml:"username"`
+	Units    []unitSpec `yaml:"units"`
+}
+
+type unitSpec struct {
+	line     int
+	Rank     string `yaml:"rank"`
+	Location string `yaml:"location"`
+}
+
+type victorySpec struct {
+	lines        map[string]int
+	Territories  int  `yaml:"territories"`
+	LastStanding bool `yaml:"last_standing"`
+}
+
+type combatSpec struct {
+	lines            map[string]int
+	Resolver         string `yaml:"resolver"`
+	Seed             int64  `yaml:"seed"`
+	AttackerModifier int    `yaml:"attacker_modifier"`
+	DefenderModifier int    `yaml:"defender_modifier"`
+}
+
+func (f *scenarioFile) UnmarshalYAML(n *yaml.Node) error {
+	lines, err := fieldLines(n, "name", "max_move_cost", "spawn_budget", "ranks", "territories", "edges", "players", "victory", "combat")
+	if err != nil {
+		return err
+	}
+	type plain scenarioFile
+	f.lines = lines
+	return n.Decode((*plain)(f))
+}
+
+func (r *rankSpec) UnmarshalYAML(n *yaml.Node) error {
+	if _, err := fieldLines(n, "name", "power", "cost"); err != nil {
+		return err
+	}
+	type plain rankSpec
+	r.line = n.Line
+	return n.Decode((*plain)(r))
+}
+
+func (t *territorySpec) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind != yaml.ScalarNode {
+		return &ScenarioError{Line: n.Line, Msg: "a territory is just its name"}
+	}
+	t.line = n.Line
+	t.name = n.Value
+	return nil
+}
+
+func (e *edgeSpec) UnmarshalYAML(n *yaml.Node) error {
+	if _, err := fieldLines(n, "from", "to", "cost"); err != nil {
+		return err
+	}
+	type plain edgeSpec
+	e.line = n.Line
+	return n.Decode((*plain)(e))
+}
+
+func (p *playerSpec) UnmarshalYAML(n *yaml.Node) error {
+	if _, err := fieldLines(n, "username", "units"); err != nil {
+		return err
+	}
+	type plain playerSpec
+	p.line = n.Line
+	return n.Decode((*plain)(p))
+}
+
+func (u *unitSpec) UnmarshalYAML(n *yaml.Node) error {
+	if _, err := fieldLines(n, "rank", "location"); err != nil {
+		return err
+	}
+	type plain unitSpec
+	u.line = n.Line
+	return n.Decode((*plain)(u))
+}
+
+func (v *victorySpec) UnmarshalYAML(n *yaml.Node) error {
+	lines, err := fieldLines(n, "territories", "last_standing")
+	if err != nil {
+		return err
+	}
+	type plain victorySpec
+	v.lines = lines
+	return n.Decode((*plain)(v))
+}
+
+func (c *combatSpec) UnmarshalYAML(n *yaml.Node) error {
+	lines, err := fieldLines(n, "resolver", "seed", "attacker_modifier", "defender_modifier")
+	if err != nil {
+		return err
+	}
+	type plain combatSpec
+	c.lines = lines
+	return n.Decode((*plain)(c))
+}
+
+// fieldLines checks n is a mapping of only the allowed keys, and returns the
+// line each key is on.
+func fieldLines(n *yaml.Node, allowed ...string) (map[string]int, error) {
+	if n.Kind != yaml.MappingNode {
+		return nil, &ScenarioError{Line: n.Line, Msg: fmt.Sprintf("expected a mapping with keys %s", strings.Join(allowed, ", "))}
+	}
+	lines := map[string]int{}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key := n.Content[i]
+		known := false
+		for _, name := range allowed {
+			known = known || key.Value == name
+		}
+		if !known {
+			return nil, &ScenarioError{Line: key.Line, Msg: fmt.Sprintf("unknown field %q, expected one of %s", key.Value, strings.Join(allowed, ", "))}
+		}
+		lines[key.Value] = key.Line
+	}
+	return lines, nil
+}
+
+// decodeError turns a decoding error into ScenarioErrors. The YAML package
+// reports lines as "line N: ..." inside its messages.
+func decodeError(path string, err error) error {
+	var scenarioErr *ScenarioError
+	if errors.As(err, &scenarioErr) {
+		scenarioErr.Path = path
+		return scenarioErr
+	}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		errs := []error{}
+		for _, msg := range typeErr.Errors {
+			errs = append(errs, lineError(path, msg))
+		}
+		return errors.Join(errs...)
+	}
+	return lineError(path, strings.TrimPrefix(err.Error(), "yaml: "))
+}
+
+func lineError(path, msg string) error {
+	var line int
+	if _, err := fmt.Sscanf(msg, "line %d:", &line); err == nil {
+		_, msg, _ = strings.Cut(msg, ": ")
+	}
+	return &ScenarioError{Path: path, Line: line, Msg: msg}
+}
+
+// build checks the file makes sense as a whole and turns it into a Scenario.
+func (f *scenarioFile) build(path string) (*Scenario, error) {
+	errs := []error{}
+	fail := func(line int, format string, args ...interface{}) {
+		errs = append(errs, &ScenarioError{Path: path, Line: line, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	s := &Scenario{
+		Name:          f.Name,
+		Ranks:         map[UnitRank]Rank{},
+		SpawnBudget:   f.SpawnBudget,
+		StartingUnits: map[string][]Unit{},
+		Victory: Victory{
+			Territories:  f.Victory.Territories,
+			LastStanding: f.Victory.LastStanding,
+		},
+	}
+	if s.Name == "" {
+		s.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	maxMoveCost := DefaultWorldMap().MaxMoveCost
+	if f.MaxMoveCost != nil {
+		maxMoveCost = *f.MaxMoveCost
+		if maxMoveCost < 1 {
+			fail(f.lines["max_move_cost"], "max_move_cost must be at least 1")
+		}
+	}
+	if f.SpawnBudget < 0 {
+		fail(f.lines["spawn_budget"], "spawn_budget can not be negative")
+	}
+	s.Map = NewWorldMap(maxMoveCost)
+
+	if len(f.Ranks) == 0 {
+		fail(f.lines["ranks"], "at least one rank is needed")
+	}
+	for _, r := range f.Ranks {
+		switch {
+		case r.Name == "":
+			fail(r.line, "a rank needs a name")
+		case s.Ranks[UnitRank(r.Name)].Name != "":
+			fail(r.line, "rank %s is defined twice", r.Name)
+		case r.Power < 0 || r.Cost < 0:
+			fail(r.line, "rank %s can not have a negative power or cost", r.Name)
+		default:
+			s.Ranks[UnitRank(r.Name)] = Rank{Name: UnitRank(r.Name), Power: r.Power, Cost: r.Cost}
+		}
+	}
+
+	if len(f.Territories) == 0 {
+		fail(f.lines["territories"], "at least one territory is needed")
+	}
+	for _, t := range f.Territories {
+		switch {
+		case t.name == "":
+			fail(t.line, "a territory needs a name")
+		case strings.Contains(t.name, ","):
+			fail(t.line, "territory %s can not have a comma in its name", t.name)
+		case s.Map.HasTerritory(Location(t.name)):
+			fail(t.line, "territory %s is listed twice", t.name)
+		default:
+			s.Map.AddTerritory(Location(t.name))
+		}
+	}
+
+	for _, e := range f.Edges {
+		if _, ok := s.Map.Cost(Location(e.From), Location(e.To)); ok {
+			fail(e.line, "%s and %s are already connected", e.From, e.To)
+			continue
+		}
+		if err := s.Map.Connect(Location(e.From), Location(e.To), e.Cost); err != nil {
+			fail(e.line, "%v", err)
+		}
+	}
+
+	for _, p := range f.Players {
+		// Usernames end up in routing keys, so they are held to the same
+		// rules as the ones players type in.
+		invalid := ValidateUsername(p.Username)
+		switch {
+		case p.Username == "":
+			fail(p.line, "a player needs a username")
+			continue
+		case invalid != nil:
+			fail(p.line, "username %s: %v", p.Username, invalid)
+			continue
+		case s.StartingUnits[p.Username] != nil:
+			fail(p.line, "player %s is listed twice", p.Username)
+			continue
+		}
+		units := []Unit{}
+		for _, u := range p.Units {
+			if _, ok := s.Ranks[UnitRank(u.Rank)]; !ok {
+				fail(u.line, "%s is not a rank", u.Rank)
+				continue
+			}
+			if !s.Map.HasTerritory(Location(u.Location)) {
+				fail(u.line, "%s is not a territory", u.Location)
+				continue
+			}
+			units = append(units, Unit{
+				ID:       len(units) + 1,
+				Rank:     UnitRank(u.Rank),
+				Location: Location(u.Location),
+			})
+		}
+		s.StartingUnits[p.Username] = units
+	}
+
+	if f.Victory.Territories < 0 || f.Victory.Territories > len(f.Territories) {
+		fail(f.Victory.lines["territories"], "victory needs between 0 and %d territories", len(f.Territories))
+	}
+
+	switch f.Combat.Resolver {
+	case "", "power":
+		for _, key := range []string{"seed", "attacker_modifier", "defender_modifier"} {
+			if line, ok := f.Combat.lines[key]; ok {
+				fail(line, "%s only applies to the dice resolver", key)
+			}
+		}
+	case "dice":
+		// Without a seed every game rolls differently.
+		seed := f.Combat.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		s.Combat = NewSeededDiceResolver(seed, f.Combat.AttackerModifier, f.Combat.DefenderModifier)
+	default:
+		fail(f.Combat.lines["resolver"], "%s is not a combat resolver, expected power or dice", f.Combat.Resolver)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return s, nil
+}
@@ -0,0 +1,208 @@
+package gamelogic
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// scenarioErrors flattens the ScenarioErrors LoadScenario and ParseScenario
+// join together.
+func scenarioErrors(t *testing.T, err error) []*ScenarioError {
+	t.Helper()
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+	found := []*ScenarioError{}
+	for _, err := range errs {
+		var scenarioErr *ScenarioError
+		if !errors.As(err, &scenarioErr) {
+			t.Fatalf("%v is not a ScenarioError", err)
+		}
+		found = append(found, scenarioErr)
+	}
+	return found
+}
+
+func TestParseScenario(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		// line and msg are those of the only ScenarioError expected; a zero
+		// line means the scenario is valid.
+		line int
+		msg  string
+	}{
+		{
+			name: "valid yaml",
+			data: `name: duel
+ranks:
+  - {name: infantry, power: 1, cost: 1}
+territories: [north, south]
+edges:
+  - {from: north, to: south, cost: 1}
+players:
+  - username: alice
+    units:
+      - {rank: infantry, location: north}
+`,
+		},
+		{
+			name: "valid json",
+			data: `{
+  "name": "duel",
+  "ranks": [{"name": "infantry", "power": 1, "cost": 1}],
+  "territories": ["north", "south"],
+  "edges": [{"from": "north", "to": "south", "cost": 1}],
+  "players": [{"username": "alice", "units": [{"rank": "infantry", "location": "north"}]}]
+}`,
+		},
+		{
+			name: "edge to a territory that does not exist",
+			data: `ranks:
+  - {name: infantry, power: 1, cost: 1}
+territories: [north, south]
+edges:
+  - {from: north, to: south, cost: 1}
+  - {from: north, to: nowhere, cost: 1}
+`,
+			line: 6,
+			msg:  "nowhere is not a territory",
+		},
+		{
+			name: "edge to a territory that does not exist in json",
+			data: `{
+  "ranks": [{"name": "infantry", "power": 1, "cost": 1}],
+  "territories": ["north", "south"],
+  "edges": [
+    {"from": "north", "to": "nowhere", "cost": 1}
+  ]
+}`,
+			line: 5,
+			msg:  "nowhere is not a territory",
+		},
+		{
+			name: "unit of an unknown rank",
+			data: `ranks:
+  - {name: infantry, power: 1, cost: 1}
+territories: [north]
+players:
+  - username: alice
+    units:
+      - {rank: infantry, location: north}
+      - {rank: dragon, location: north}
+`,
+			line: 8,
+			msg:  "dragon is not a rank",
+		},
+		{
+			name: "player listed twice",
+			data: `ranks:
+  - {name: infantry, power: 1, cost: 1}
+territories: [north]
+players:
+  - username: alice
+  - username: bob
+  - username: alice
+`,
+			line: 7,
+			msg:  "player alice is listed twice",
+		},
+		{
+			name: "username with a dot",
+			data: `ranks:
+  - {name: infantry, power: 1, cost: 1}
+territories: [north]
+players:
+  - username: alice.smith
+`,
+			line: 5,
+			msg:  `username alice.smith: usernames can not contain '.'`,
+		},
+		{
+			name: "username with a slash",
+			data: `{
+  "ranks": [{"name": "infantry", "power": 1, "cost": 1}],
+  "territories": ["north"],
+  "players": [
+    {"username": "alice/bob"}
+  ]
+}`,
+			line: 5,
+			msg:  `username alice/bob: usernames can not contain '/'`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseScenario("test.yaml", []byte(tt.data))
+			if tt.line == 0 {
+				if err != nil {
+					t.Fatalf("ParseScenario: %v", err)
+				}
+				if s.Name != "duel" || len(s.StartingUnits["alice"]) != 1 {
+					t.Errorf("parsed %s with %d of alice's units, want duel with 1", s.Name, len(s.StartingUnits["alice"]))
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("ParseScenario accepted the scenario")
+			}
+			errs := scenarioErrors(t, err)
+			if len(errs) != 1 {
+				t.Fatalf("got %d errors, want 1: %v", len(errs), err)
+			}
+			if got := errs[0]; got.Line != tt.line || got.Msg != tt.msg || got.Path != "test.yaml" {
+				t.Errorf("got %v, want test.yaml:%d: %s", got, tt.line, tt.msg)
+			}
+		})
+	}
+}
+
+func TestLoadScenarioReadsTabIndentedJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "duel.json")
+	data := "{\n\t\"ranks\": [{\"name\": \"infantry\", \"power\": 1, \"cost\": 1}],\n\t\"territories\": [\"north\"],\n\t\"players\": [\n\t\t{\"username\": \"alice\"},\n\t\t{\"username\": \"alice\"}\n\t]\n}\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	_, err := LoadScenario(path)
+	if err == nil {
+		t.Fatal("LoadScenario accepted a player listed twice")
+	}
+	errs := scenarioErrors(t, err)
+	if len(errs) != 1 || errs[0].Line != 6 || errs[0].Path != path {
+		t.Errorf("got %v, want the duplicate player on line 6 of %s", err, path)
+	}
+
+	if err := os.WriteFile(path, []byte(strings.Replace(data, "\t\t{\"username\": \"alice\"}\n", "", 1)), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := LoadScenario(path); err != nil {
+		t.Errorf("LoadScenario: %v", err)
+	}
+}
+
+func TestLoadScenarioFiles(t *testing.T) {
+	for _, path := range []string{"../../scenarios/classic.yaml", "../../scenarios/skirmish.json"} {
+		if _, err := LoadScenario(path); err != nil {
+			t.Errorf("LoadScenario: %v", err)
+		}
+	}
+}
+
+func TestVictorLeavesPlayersInOrder(t *testing.T) {
+	s := DefaultScenario()
+	s.Victory.LastStanding = true
+	players := []Player{
+		{Username: "bob"},
+		{Username: "alice", Units: map[int]Unit{1: {ID: 1, Rank: RankInfantry, Location: "europe"}}},
+	}
+	if winner, ok := s.Victor(players); !ok || winner != "alice" {
+		t.Errorf("Victor = %q, %v, want alice", winner, ok)
+	}
+	if players[0].Username != "bob" {
+		t.Error("Victor reordered the players it was given")
+	}
+}
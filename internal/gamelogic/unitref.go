@@ -0,0 +1,51 @@
+package gamelogic
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// UnitRef names a unit across every player, as <username>/<id>. Unit IDs are
+// only unique within one player's units, so messages about more than one
+// player's units use refs.
+type UnitRef string
+
+func NewUnitRef(username string, id int) UnitRef {
+	return UnitRef(fmt.Sprintf("%s/%d", username, id))
+}
+
+func (r UnitRef) Parse() (username string, id int, err error) {
+	// The ID is after the last slash; ValidateUsername keeps slashes out
+	// of usernames, but refs from elsewhere may still have them.
+	i := strings.LastIndex(string(r), "/")
+	if i < 1 {
+		return "", 0, fmt.Errorf("%q is not a unit ref", r)
+	}
+	username, seq := string(r[:i]), string(r[i+1:])
+	id, err = strconv.Atoi(seq)
+	if err != nil || id < 1 {
+		return "", 0, fmt.Errorf("%q is not a unit ref", r)
+	}
+	return username, id, nil
+}
+
+func unitRefs(username string, units []Unit) []UnitRef {
+	refs := []UnitRef{}
+	for _, unit := range units {
+		refs = append(refs, NewUnitRef(username, unit.ID))
+	}
+	return refs
+}
+
+// unitsOf picks out the IDs of username's units from refs.
+func unitsOf(username string, refs []UnitRef) []int {
+	ids := []int{}
+	for _, ref := range refs {
+		owner, id, err := ref.Parse()
+		if err == nil && owner == username {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
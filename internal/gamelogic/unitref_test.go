@@ -0,0 +1,54 @@
+package gamelogic
+
+import "testing"
+
+func TestUnitRefParse(t *testing.T) {
+	tests := []struct {
+		ref      UnitRef
+		username string
+		id       int
+		ok       bool
+	}{
+		{ref: NewUnitRef("alice", 7), username: "alice", id: 7, ok: true},
+		{ref: "alice/12", username: "alice", id: 12, ok: true},
+		// Only the last slash separates the ID.
+		{ref: "team/alice/3", username: "team/alice", id: 3, ok: true},
+		{ref: "alice", ok: false},
+		{ref: "/3", ok: false},
+		{ref: "alice/", ok: false},
+		{ref: "alice/0", ok: false},
+		{ref: "alice/-1", ok: false},
+		{ref: "alice/x", ok: false},
+	}
+	for _, tt := range tests {
+		username, id, err := tt.ref.Parse()
+		if (err == nil) != tt.ok {
+			t.Errorf("%q.Parse() error = %v, want ok = %v", tt.ref, err, tt.ok)
+			continue
+		}
+		if username != tt.username || id != tt.id {
+			t.Errorf("%q.Parse() = %q, %d, want %q, %d", tt.ref, username, id, tt.username, tt.id)
+		}
+	}
+}
+
+func TestUnitsOf(t *testing.T) {
+	refs := []UnitRef{NewUnitRef("alice", 1), NewUnitRef("bob", 1), NewUnitRef("alice", 4), "junk"}
+	got := unitsOf("alice", refs)
+	if len(got) != 2 || got[0] != 1 || got[1] != 4 {
+		t.Errorf("unitsOf(alice) = %v, want [1 4]", got)
+	}
+}
+
+func TestValidateUsername(t *testing.T) {
+	for _, username := range []string{"alice", "bob_2", "Zoë"} {
+		if err := ValidateUsername(username); err != nil {
+			t.Errorf("ValidateUsername(%q) = %v, want nil", username, err)
+		}
+	}
+	for _, username := range []string{"", "a/b", "a.b", "a*", "#"} {
+		if err := ValidateUsername(username); err == nil {
+			t.Errorf("ValidateUsername(%q) = nil, want an error", username)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package gamelogic
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 )
 
 type WarOutcome int
@@ -14,92 +16,192 @@ const (
 	WarOutcomeDraw
 )
 
-func (gs *GameState) HandleWar(rw RecognitionOfWar) (outcome WarOutcome, winner string, loser string) {
-	defer fmt.Println("------------------------")
-	fmt.Println()
-	fmt.Println("==== War Declared ====")
-	fmt.Printf("%s has declared war on %s!\n", rw.Attacker.Username, rw.Defender.Username)
+// Battle is the fighting in one location of a war.
+type Battle struct {
+	Location      Location
+	AttackerUnits []Unit
+	DefenderUnits []Unit
+	AttackerPower int
+	DefenderPower int
+	// Winner and Loser are the attacker and defender when Draw is set.
+	Winner string
+	Loser  string
+	Draw   bool
+	// Casualties are the units killed, on both sides.
+	Casualties []UnitRef
+}
 
-	player := gs.GetPlayerSnap()
+// WarReport is a war fought on every front the two players share, one
+// battle per location, in location order.
+type WarReport struct {
+	Attacker string
+	Defender string
+	Battles  []Battle
+}
 
-	if player.Username == rw.Defender.Username {
-		fmt.Printf("%s, you published the war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
+// ResolveWar fights a battle in every location where attacker and defender
+// both have units, settling each with the scenario's CombatResolver.
+func (s *Scenario) ResolveWar(attacker, defender Player) WarReport {
+	report := WarReport{
+		Attacker: attacker.Username,
+		Defender: defender.Username,
 	}
-
-	if player.Username != rw.Attacker.Username {
-		fmt.Printf("%s, you are not involved in this war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
+	for _, loc := range getOverlappingLocations(attacker, defender) {
+		battle := Battle{
+			Location:      loc,
+			AttackerUnits: unitsInLocation(attacker, loc),
+			DefenderUnits: unitsInLocation(defender, loc),
+			Casualties:    []UnitRef{},
+		}
+		battle.AttackerPower = s.power(battle.AttackerUnits)
+		battle.DefenderPower = s.power(battle.DefenderUnits)
+		s.combat().Fight(s.Ranks, attacker.Username, defender.Username, &battle)
+		report.Battles = append(report.Battles, battle)
 	}
+	return report
+}
 
-	overlappingLocation := getOverlappingLocation(rw.Attacker, rw.Defender)
-	if overlappingLocation == "" {
-		fmt.Printf("Error! No units are in the same location. No war will be fought.\n")
-		return WarOutcomeNoUnits, "", ""
+// MissingFronts lists the fronts, of those declared, where the war had no
+// battle.
+func (r WarReport) MissingFronts(declared []Location) []Location {
+	fought := map[Location]bool{}
+	for _, battle := range r.Battles {
+		fought[battle.Location] = true
 	}
-
-	attackerUnits := []Unit{}
-	defenderUnits := []Unit{}
-	for _, unit := range rw.Attacker.Units {
-		if unit.Location == overlappingLocation {
-			attackerUnits = append(attackerUnits, unit)
+	missing := []Location{}
+	for _, loc := range declared {
+		if !fought[loc] {
+			missing = append(missing, loc)
 		}
 	}
-	for _, unit := range rw.Defender.Units {
-		if unit.Location == overlappingLocation {
-			defenderUnits = append(defenderUnits, unit)
+	return missing
+}
+
+// Result decides the war by battles won. The attacker and defender are
+// returned as winner and loser when draw is set.
+func (r WarReport) Result() (winner, loser string, draw bool) {
+	attackerWins, defenderWins := 0, 0
+	for _, battle := range r.Battles {
+		switch {
+		case battle.Draw:
+		case battle.Winner == r.Attacker:
+			attackerWins++
+		default:
+			defenderWins++
 		}
 	}
+	switch {
+	case attackerWins > defenderWins:
+		return r.Attacker, r.Defender, false
+	case defenderWins > attackerWins:
+		return r.Defender, r.Attacker, false
+	default:
+		return r.Attacker, r.Defender, true
+	}
+}
 
-	fmt.Printf("%s's units:\n", rw.Attacker.Username)
-	for _, unit := range attackerUnits {
-		fmt.Printf("  * %v\n", unit.Rank)
-	}
-	fmt.Printf("%s's units:\n", rw.Defender.Username)
-	for _, unit := range defenderUnits {
-		fmt.Printf("  * %v\n", unit.Rank)
-	}
-	attackerPower := unitsToPowerLevel(attackerUnits)
-	defenderPower := unitsToPowerLevel(defenderUnits)
-	fmt.Printf("Attacker has a power level of %v\n", attackerPower)
-	fmt.Printf("Defender has a power level of %v\n", defenderPower)
-	if attackerPower > defenderPower {
-		fmt.Printf("%s has won the war!\n", rw.Attacker.Username)
-		if player.Username == rw.Defender.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Attacker.Username, rw.Defender.Username
-		}
-		return WarOutcomeYouWon, rw.Attacker.Username, rw.Defender.Username
-	} else if defenderPower > attackerPower {
-		fmt.Printf("%s has won the war!\n", rw.Defender.Username)
-		if player.Username == rw.Attacker.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Defender.Username, rw.Attacker.Username
+// OutcomeFor reports how the war went for username.
+func (r WarReport) OutcomeFor(username string) WarOutcome {
+	if username != r.Attacker && username != r.Defender {
+		return WarOutcomeNotInvolved
+	}
+	if len(r.Battles) == 0 {
+		return WarOutcomeNoUnits
+	}
+	winner, _, draw := r.Result()
+	switch {
+	case draw:
+		return WarOutcomeDraw
+	case username == winner:
+		return WarOutcomeYouWon
+	default:
+		return WarOutcomeOpponentWon
+	}
+}
+
+// Summary describes the war in one line, for the game log.
+func (r WarReport) Summary() string {
+	winner, loser, draw := r.Result()
+	summary := fmt.Sprintf("%s won a war against %s", winner, loser)
+	if draw {
+		summary = fmt.Sprintf("A war between %s and %s resulted in a draw", winner, loser)
+	}
+	fronts := []string{}
+	for _, battle := range r.Battles {
+		if battle.Draw {
+			fronts = append(fronts, fmt.Sprintf("%s: draw", battle.Location))
+		} else {
+			fronts = append(fronts, fmt.Sprintf("%s: %s won", battle.Location, battle.Winner))
 		}
-		return WarOutcomeYouWon, rw.Defender.Username, rw.Attacker.Username
 	}
-	fmt.Println("The war ended in a draw!")
-	fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-	gs.removeUnitsInLocation(overlappingLocation)
-	return WarOutcomeDraw, rw.Attacker.Username, rw.Defender.Username
+	if len(fronts) > 1 {
+		summary += " (" + strings.Join(fronts, ", ") + ")"
+	}
+	return summary
 }
 
-func unitsToPowerLevel(units []Unit) int {
-	power := 0
-	for _, unit := range units {
-		if unit.Rank == RankArtillery {
-			power += 10
+// ApplyWarReport kills the player's units lost in the war and reports how
+// it went for them.
+func (gs *GameState) ApplyWarReport(report WarReport) WarOutcome {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== War Declared ====")
+	fmt.Printf("%s has declared war on %s!\n", report.Attacker, report.Defender)
+
+	username := gs.GetUsername()
+	outcome := report.OutcomeFor(username)
+	switch outcome {
+	case WarOutcomeNotInvolved:
+		fmt.Printf("%s, you are not involved in this war.\n", username)
+		return outcome
+	case WarOutcomeNoUnits:
+		fmt.Printf("Error! No units are in the same location. No war will be fought.\n")
+		return outcome
+	}
+
+	for _, battle := range report.Battles {
+		fmt.Printf("== Battle of %s ==\n", battle.Location)
+		fmt.Printf("%s's units:\n", report.Attacker)
+		for _, unit := range battle.AttackerUnits {
+			fmt.Printf("  * %v\n", unit.Rank)
+		}
+		fmt.Printf("%s's units:\n", report.Defender)
+		for _, unit := range battle.DefenderUnits {
+			fmt.Printf("  * %v\n", unit.Rank)
 		}
-		if unit.Rank == RankCavalry {
-			power += 5
+		fmt.Printf("Attacker has a power level of %v\n", battle.AttackerPower)
+		fmt.Printf("Defender has a power level of %v\n", battle.DefenderPower)
+		if battle.Draw {
+			fmt.Println("The battle ended in a draw!")
+		} else {
+			fmt.Printf("%s has won the battle!\n", battle.Winner)
 		}
-		if unit.Rank == RankInfantry {
-			power += 1
+		if lost := unitsOf(username, battle.Casualties); len(lost) > 0 {
+			gs.removeUnits(lost)
+			fmt.Printf("Your units in %s have been killed.\n", battle.Location)
+		}
+	}
+
+	winner, _, _ := report.Result()
+	switch outcome {
+	case WarOutcomeDraw:
+		fmt.Println("The war ended in a draw!")
+	case WarOutcomeOpponentWon:
+		fmt.Printf("%s has won the war!\n", winner)
+		fmt.Println("You have lost the war!")
+	default:
+		fmt.Printf("%s has won the war!\n", winner)
+	}
+	return outcome
+}
+
+func unitsInLocation(player Player, loc Location) []Unit {
+	units := []Unit{}
+	for _, unit := range player.Units {
+		if unit.Location == loc {
+			units = append(units, unit)
 		}
 	}
-	return power
+	sort.Slice(units, func(i, j int) bool { return units[i].ID < units[j].ID })
+	return units
 }
@@ -0,0 +1,46 @@
+package gamelogic
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// WarResult is the server's ruling on a war. Both players apply it as is,
+// so it is signed by the server and clients drop results that do not verify.
+type WarResult struct {
+	// WarID is the message ID of the declaration that started the war.
+	WarID string
+	WarReport
+	ResolvedAt time.Time
+	Signature  []byte
+}
+
+var ErrBadSignature = errors.New("war result signature does not verify")
+
+// signedBytes is what the signature covers: every field but the signature.
+func (r WarResult) signedBytes() ([]byte, error) {
+	r.Signature = nil
+	return json.Marshal(r)
+}
+
+func (r *WarResult) Sign(key ed25519.PrivateKey) error {
+	msg, err := r.signedBytes()
+	if err != nil {
+		return err
+	}
+	r.Signature = ed25519.Sign(key, msg)
+	return nil
+}
+
+func (r WarResult) Verify(key ed25519.PublicKey) error {
+	msg, err := r.signedBytes()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, msg, r.Signature) {
+		return ErrBadSignature
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package gamelogic
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Edge connects two adjacent territories. Moving along it, either way, costs
+// Cost.
+type Edge struct {
+	From Location
+	To   Location
+	Cost int
+}
+
+// WorldMap is the board: territories and the edges between them. A unit may
+// move along any route whose edges cost no more than MaxMoveCost in total.
+type WorldMap struct {
+	MaxMoveCost int
+	territories map[Location]bool
+	edges       map[Location]map[Location]int
+}
+
+func NewWorldMap(maxMoveCost int) *WorldMap {
+	return &WorldMap{
+		MaxMoveCost: maxMoveCost,
+		territories: map[Location]bool{},
+		edges:       map[Location]map[Location]int{},
+	}
+}
+
+// DefaultWorldMap is the six continents, with crossing an ocean costing
+// twice as much as crossing land.
+func DefaultWorldMap() *WorldMap {
+	m := NewWorldMap(3)
+	for loc := range getAllLocations() {
+		m.AddTerritory(loc)
+	}
+	for _, e := range []Edge{
+		{"americas", "europe", 2},
+		{"americas", "asia", 2},
+		{"americas", "antarctica", 2},
+		{"europe", "africa", 1},
+		{"europe", "asia", 1},
+		{"africa", "asia", 1},
+		{"africa", "antarctica", 2},
+		{"asia", "australia", 1},
+		{"australia", "antarctica", 2},
+	} {
+		m.Connect(e.From, e.To, e.Cost)
+	}
+	return m
+}
+
+func (m *WorldMap) AddTerritory(loc Location) {
+	m.territories[loc] = true
+}
+
+// Connect adds an edge between two territories already on the map.
+func (m *WorldMap) Connect(a, b Location, cost int) error {
+	if !m.territories[a] {
+		return fmt.Errorf("%s is not a territory", a)
+	}
+	if !m.territories[b] {
+		return fmt.Errorf("%s is not a territory", b)
+	}
+	if a == b {
+		return fmt.Errorf("%s can not be connected to itself", a)
+	}
+	if cost < 1 {
+		return fmt.Errorf("the edge between %s and %s must cost at least 1", a, b)
+	}
+	for _, pair := range [][2]Location{{a, b}, {b, a}} {
+		if m.edges[pair[0]] == nil {
+			m.edges[pair[0]] = map[Location]int{}
+		}
+		m.edges[pair[0]][pair[1]] = cost
+	}
+	return nil
+}
+
+func (m *WorldMap) HasTerritory(loc Location) bool {
+	return m.territories[loc]
+}
+
+func (m *WorldMap) Territories() []Location {
+	locs := []Location{}
+	for loc := range m.territories {
+		locs = append(locs, loc)
+	}
+	sortLocations(locs)
+	return locs
+}
+
+// Edges lists every edge once, with From before To.
+func (m *WorldMap) Edges() []Edge {
+	edges := []Edge{}
+	for _, from := range m.Territories() {
+		for _, to := range m.Neighbors(from) {
+			if from < to {
+				edges = append(edges, Edge{From: from, To: to, Cost: m.edges[from][to]})
+			}
+		}
+	}
+	return edges
+}
+
+func (m *WorldMap) Neighbors(loc Location) []Location {
+	locs := []Location{}
+	for to := range m.edges[loc] {
+		locs = append(locs, to)
+	}
+	sortLocations(locs)
+	return locs
+}
+
+// Cost is the cost of the edge between a and b, if they are adjacent.
+func (m *WorldMap) Cost(a, b Location) (int, bool) {
+	cost, ok := m.edges[a][b]
+	return cost, ok
+}
+
+// RouteCost totals the edges along route, which must go from territory to
+// adjacent territory.
+func (m *WorldMap) RouteCost(route []Location) (int, error) {
+	total := 0
+	for i := 1; i < len(route); i++ {
+		cost, ok := m.Cost(route[i-1], route[i])
+		if !ok {
+			return 0, fmt.Errorf("%s is not adjacent to %s", route[i], route[i-1])
+		}
+		total += cost
+	}
+	return total, nil
+}
+
+// ShortestPath finds the cheapest route from one territory to another. The
+// route starts with from and ends with to.
+func (m *WorldMap) ShortestPath(from, to Location) ([]Location, int, bool) {
+	if !m.territories[from] || !m.territories[to] {
+		return nil, 0, false
+	}
+	dist := map[Location]int{from: 0}
+	prev := map[Location]Location{}
+	done := map[Location]bool{}
+	for {
+		// The map is small, so scanning for the nearest territory is
+		// cheaper than keeping a heap. Ties go to the first by name so
+		// routes are stable.
+		current, found := Location(""), false
+		for _, loc := range m.Territories() {
+			d, ok := dist[loc]
+			if !ok || done[loc] {
+				continue
+			}
+			if !found || d < dist[current] {
+				current, found = loc, true
+			}
+		}
+		if !found {
+			return nil, 0, false
+		}
+		if current == to {
+			break
+		}
+		done[current] = true
+		for _, next := range m.Neighbors(current) {
+			d := dist[current] + m.edges[current][next]
+			if old, ok := dist[next]; !ok || d < old {
+				dist[next] = d
+				prev[next] = current
+			}
+		}
+	}
+
+	route := []Location{to}
+	for loc := to; loc != from; {
+		loc = prev[loc]
+		route = append([]Location{loc}, route...)
+	}
+	return route, dist[to], true
+}
+
+// FormatRoute writes a route the way the move command takes it.
+func FormatRoute(route []Location) string {
+	names := []string{}
+	for _, loc := range route {
+		names = append(names, string(loc))
+	}
+	return strings.Join(names, ",")
+}
+
+func sortLocations(locs []Location) {
+	sort.Slice(locs, func(i, j int) bool { return locs[i] < locs[j] })
+}
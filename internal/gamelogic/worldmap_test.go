@@ -0,0 +1,47 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestShortestPath(t *testing.T) {
+	m := NewWorldMap(3)
+	for _, loc := range []Location{"north", "south", "east", "west", "island"} {
+		m.AddTerritory(loc)
+	}
+	for _, e := range []Edge{
+		{"north", "south", 5},
+		{"north", "east", 1},
+		{"east", "south", 1},
+		{"north", "west", 1},
+		{"west", "south", 2},
+	} {
+		if err := m.Connect(e.From, e.To, e.Cost); err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		from, to Location
+		route    []Location
+		cost     int
+		ok       bool
+	}{
+		{name: "cheaper route over the direct edge", from: "north", to: "south", route: []Location{"north", "east", "south"}, cost: 2, ok: true},
+		{name: "back the other way", from: "south", to: "north", route: []Location{"south", "east", "north"}, cost: 2, ok: true},
+		{name: "adjacent", from: "north", to: "west", route: []Location{"north", "west"}, cost: 1, ok: true},
+		{name: "already there", from: "east", to: "east", route: []Location{"east"}, cost: 0, ok: true},
+		{name: "unreachable", from: "north", to: "island"},
+		{name: "not a territory", from: "north", to: "atlantis"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route, cost, ok := m.ShortestPath(tt.from, tt.to)
+			if ok != tt.ok || cost != tt.cost || !reflect.DeepEqual(route, tt.route) {
+				t.Errorf("ShortestPath = %v, %d, %v, want %v, %d, %v", route, cost, ok, tt.route, tt.cost, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func writeText(t *testing.T, r *Registry) string {
+	t.Helper()
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	return b.String()
+}
+
+func TestCounterText(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("peril_messages_total", "Messages handled,\nby queue. Paths use \\.", "queue", "outcome")
+	c.Inc("war", "ack")
+	c.Add(2, "war", "ack")
+	c.Inc(`say "hi"`+"\n"+`C:\logs`, "nack")
+
+	want := `# HELP peril_messages_total Messages handled,\nby queue. Paths use \\.
+# TYPE peril_messages_total counter
+peril_messages_total{queue="say \"hi\"\nC:\\logs",outcome="nack"} 1
+peril_messages_total{queue="war",outcome="ack"} 3
+`
+	if got := writeText(t, r); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramText(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("peril_handler_seconds", "Handler latency.", []float64{0.1, 1}, "queue")
+	for _, v := range []float64{0.05, 0.5, 0.5, 3} {
+		h.Observe(v, "war")
+	}
+
+	// Buckets are cumulative: each counts every observation up to its
+	// bound, and +Inf counts them all.
+	want := `# HELP peril_handler_seconds Handler latency.
+# TYPE peril_handler_seconds histogram
+peril_handler_seconds_bucket{queue="war",le="0.1"} 1
+peril_handler_seconds_bucket{queue="war",le="1"} 3
+peril_handler_seconds_bucket{queue="war",le="+Inf"} 4
+peril_handler_seconds_sum{queue="war"} 4.05
+peril_handler_seconds_count{queue="war"} 4
+`
+	if got := writeText(t, r); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteTextSortsByName(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("b_total", "B.").Inc()
+	r.NewCounterVec("a_total", "A.").Inc()
+	got := writeText(t, r)
+	if strings.Index(got, "a_total") > strings.Index(got, "b_total") {
+		t.Errorf("metrics are not sorted by name:\n%s", got)
+	}
+	if !strings.Contains(got, "a_total 1\n") {
+		t.Errorf("unlabelled counter is missing:\n%s", got)
+	}
+}
@@ -0,0 +1,49 @@
+package pubsub
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff computes exponentially growing delays with random jitter.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	// Jitter is the fraction of each delay that is randomized, between 0 and 1.
+	Jitter float64
+}
+
+var DefaultBackoff = Backoff{
+	Initial:    500 * time.Millisecond,
+	Max:        30 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// Delay is the delay before retry number attempt, counting from 0. A zero
+// Backoff is DefaultBackoff; otherwise a zero Initial, Max or Multiplier
+// takes DefaultBackoff's, and a zero Jitter means no jitter.
+func (b Backoff) Delay(attempt int) time.Duration {
+	if b == (Backoff{}) {
+		b = DefaultBackoff
+	}
+	if b.Initial <= 0 {
+		b.Initial = DefaultBackoff.Initial
+	}
+	if b.Max <= 0 {
+		b.Max = DefaultBackoff.Max
+	}
+	if b.Multiplier <= 0 {
+		b.Multiplier = DefaultBackoff.Multiplier
+	}
+	delay := float64(b.Initial) * math.Pow(b.Multiplier, float64(attempt))
+	if delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		delay += delay * b.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(delay)
+}
@@ -0,0 +1,55 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff Backoff
+		attempt int
+		want    time.Duration
+	}{
+		{name: "first attempt", backoff: Backoff{Initial: time.Second, Max: time.Minute, Multiplier: 3}, attempt: 0, want: time.Second},
+		{name: "grows by the multiplier", backoff: Backoff{Initial: time.Second, Max: time.Minute, Multiplier: 3}, attempt: 2, want: 9 * time.Second},
+		{name: "capped at max", backoff: Backoff{Initial: time.Second, Max: 5 * time.Second, Multiplier: 3}, attempt: 2, want: 5 * time.Second},
+		{name: "default initial", backoff: Backoff{Max: time.Minute, Multiplier: 3}, attempt: 1, want: 1500 * time.Millisecond},
+		{name: "default max", backoff: Backoff{Initial: time.Second, Multiplier: 10}, attempt: 3, want: DefaultBackoff.Max},
+		{name: "default multiplier", backoff: Backoff{Initial: time.Second, Max: time.Minute}, attempt: 2, want: 4 * time.Second},
+		{name: "only max", backoff: Backoff{Max: time.Second}, attempt: 5, want: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.backoff.Delay(tt.attempt); got != tt.want {
+				t.Errorf("Delay(%d) = %v, want %v", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff Backoff
+		// want is the delay before jitter, which may move it by jitter of
+		// itself either way.
+		want   time.Duration
+		jitter float64
+	}{
+		{name: "default", backoff: Backoff{}, want: 2 * DefaultBackoff.Initial, jitter: DefaultBackoff.Jitter},
+		{name: "custom", backoff: Backoff{Initial: time.Second, Jitter: 0.5}, want: 2 * time.Second, jitter: 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			low := time.Duration(float64(tt.want) * (1 - tt.jitter))
+			high := time.Duration(float64(tt.want) * (1 + tt.jitter))
+			for i := 0; i < 100; i++ {
+				if got := tt.backoff.Delay(1); got < low || got > high {
+					t.Fatalf("Delay(1) = %v, want between %v and %v", got, low, high)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package pubsub
+
+import (
+	"context"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Publisher is the publishing half of a channel. *amqp.Channel satisfies it.
+type Publisher interface {
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// Subscriber is the consuming half of a channel. *amqp.Channel satisfies it.
+type Subscriber interface {
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Cancel(consumer string, noWait bool) error
+}
+
+// Channel is the subset of *amqp.Channel used by Peril.
+type Channel interface {
+	Publisher
+	Subscriber
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	Close() error
+}
+
+// Broker hands out channels. It is implemented over a RabbitMQ connection by
+// NewAMQPBroker and in-process by MemoryBroker.
+type Broker interface {
+	Channel() (Channel, error)
+	Close() error
+}
+
+type amqpBroker struct {
+	conn *amqp.Connection
+}
+
+func NewAMQPBroker(conn *amqp.Connection) Broker {
+	return &amqpBroker{conn: conn}
+}
+
+func (b *amqpBroker) Channel() (Channel, error) {
+	ch, err := b.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+func (b *amqpBroker) Close() error {
+	return b.conn.Close()
+}
@@ -0,0 +1,313 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	ErrNacked             = errors.New("message nacked by broker")
+	ErrConfirmChannelLost = errors.New("channel closed before message was confirmed")
+	ErrConfirmUnsupported = errors.New("broker channel does not support publisher confirms")
+)
+
+// UnroutableError is returned for mandatory publishes that the broker could
+// not route to any queue.
+type UnroutableError struct {
+	Exchange  string
+	Key       string
+	ReplyCode uint16
+	ReplyText string
+}
+
+func (e *UnroutableError) Error() string {
+	return fmt.Sprintf("message to exchange %q with key %q was returned: %d %s", e.Exchange, e.Key, e.ReplyCode, e.ReplyText)
+}
+
+type confirmChannel interface {
+	Channel
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	NotifyReturn(c chan amqp.Return) chan amqp.Return
+}
+
+// PendingConfirm resolves once the broker has acked, nacked or returned a
+// message published through a ConfirmingPublisher.
+type PendingConfirm struct {
+	messageID string
+	done      chan struct{}
+	returned  error
+	err       error
+}
+
+func (pc *PendingConfirm) Done() <-chan struct{} {
+	return pc.done
+}
+
+func (pc *PendingConfirm) Wait(ctx context.Context) error {
+	select {
+	case <-pc.done:
+		return pc.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (pc *PendingConfirm) resolve(err error) {
+	pc.err = err
+	close(pc.done)
+}
+
+// WaitAll waits for every confirm and joins their errors.
+func WaitAll(ctx context.Context, confirms ...*PendingConfirm) error {
+	errs := []error{}
+	for _, pc := range confirms {
+		if err := pc.Wait(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ConfirmingPublisher publishes every message as mandatory on a channel in
+// confirm mode. It satisfies Publisher, in which case each publish blocks
+// until the broker confirms it or Timeout elapses.
+//
+// The publisher owns its channel. If the channel is lost, for example with
+// the connection, publishes awaiting a confirm fail with
+// ErrConfirmChannelLost and the next publish opens a new channel in confirm
+// mode.
+type ConfirmingPublisher struct {
+	broker  Broker
+	Timeout time.Duration
+
+	publishMu sync.Mutex
+	session   *confirmSession
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// confirmSession is one channel in confirm mode and the publishes awaiting a
+// confirm on it. Delivery tags start again on every channel, so each session
+// keeps its own. Its maps are guarded by the publisher's mu.
+type confirmSession struct {
+	ch      confirmChannel
+	seq     uint64
+	pending map[uint64]*PendingConfirm
+	byID    map[string]*PendingConfirm
+}
+
+func NewConfirmingPublisher(broker Broker, timeout time.Duration) (*ConfirmingPublisher, error) {
+	p := &ConfirmingPublisher{
+		broker:  broker,
+		Timeout: timeout,
+	}
+	if err := p.open(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// open starts a session on a new channel. It must be called with publishMu
+// held, or before the publisher is shared.
+func (p *ConfirmingPublisher) open() error {
+	ch, err := p.broker.Channel()
+	if err != nil {
+		return fmt.Errorf("Failed to open a channel: %v", err)
+	}
+	cch, ok := ch.(confirmChannel)
+	if !ok {
+		ch.Close()
+		return ErrConfirmUnsupported
+	}
+	if err := cch.Confirm(false); err != nil {
+		cch.Close()
+		return fmt.Errorf("Failed to enable confirm mode: %v", err)
+	}
+	s := &confirmSession{
+		ch:      cch,
+		pending: map[uint64]*PendingConfirm{},
+		byID:    map[string]*PendingConfirm{},
+	}
+	confirms := cch.NotifyPublish(make(chan amqp.Confirmation, 64))
+	returns := cch.NotifyReturn(make(chan amqp.Return, 64))
+	p.session = s
+	go p.listen(s, confirms, returns)
+	return nil
+}
+
+func (p *ConfirmingPublisher) listen(s *confirmSession, confirms <-chan amqp.Confirmation, returns <-chan amqp.Return) {
+	for {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			p.markReturned(s, ret)
+		case conf, ok := <-confirms:
+			if !ok {
+				p.channelLost(s)
+				return
+			}
+			// A return is always sent before the ack for the same message.
+			p.drainReturns(s, returns)
+
+			p.mu.Lock()
+			pc := s.pending[conf.DeliveryTag]
+			delete(s.pending, conf.DeliveryTag)
+			if pc != nil {
+				delete(s.byID, pc.messageID)
+			}
+			p.mu.Unlock()
+			if pc == nil {
+				continue
+			}
+			if !conf.Ack {
+				pc.resolve(ErrNacked)
+				continue
+			}
+			pc.resolve(pc.returned)
+		}
+	}
+}
+
+func (p *ConfirmingPublisher) drainReturns(s *confirmSession, returns <-chan amqp.Return) {
+	for {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				return
+			}
+			p.markReturned(s, ret)
+		default:
+			return
+		}
+	}
+}
+
+func (p *ConfirmingPublisher) markReturned(s *confirmSession, ret amqp.Return) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pc, ok := s.byID[ret.MessageId]; ok {
+		pc.returned = &UnroutableError{
+			Exchange:  ret.Exchange,
+			Key:       ret.RoutingKey,
+			ReplyCode: ret.ReplyCode,
+			ReplyText: ret.ReplyText,
+		}
+	}
+}
+
+// channelLost fails the session's unconfirmed publishes, whose confirms can
+// no longer arrive, and retires the session.
+func (p *ConfirmingPublisher) channelLost(s *confirmSession) {
+	p.publishMu.Lock()
+	if p.session == s {
+		p.session = nil
+	}
+	p.publishMu.Unlock()
+	s.ch.Close()
+
+	p.mu.Lock()
+	pending := s.pending
+	s.pending = map[uint64]*PendingConfirm{}
+	s.byID = map[string]*PendingConfirm{}
+	p.mu.Unlock()
+	for _, pc := range pending {
+		pc.resolve(ErrConfirmChannelLost)
+	}
+}
+
+// PublishAsync publishes msg and returns without waiting for the broker.
+func (p *ConfirmingPublisher) PublishAsync(ctx context.Context, exchange, key string, msg amqp.Publishing) (*PendingConfirm, error) {
+	p.publishMu.Lock()
+	defer p.publishMu.Unlock()
+
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		return nil, amqp.ErrClosed
+	}
+	if msg.MessageId == "" {
+		msg.MessageId = newMessageID()
+	}
+
+	pc, err := p.publishLocked(ctx, exchange, key, msg)
+	if errors.Is(err, ErrConfirmChannelLost) || errors.Is(err, amqp.ErrClosed) {
+		// The channel was gone before anything was sent, so a new one
+		// can have the message.
+		pc, err = p.publishLocked(ctx, exchange, key, msg)
+	}
+	return pc, err
+}
+
+func (p *ConfirmingPublisher) publishLocked(ctx context.Context, exchange, key string, msg amqp.Publishing) (*PendingConfirm, error) {
+	if p.session == nil {
+		if err := p.open(); err != nil {
+			return nil, err
+		}
+	}
+	s := p.session
+
+	pc := &PendingConfirm{messageID: msg.MessageId, done: make(chan struct{})}
+	seq := s.seq + 1
+	p.mu.Lock()
+	s.pending[seq] = pc
+	s.byID[pc.messageID] = pc
+	p.mu.Unlock()
+
+	if err := s.ch.PublishWithContext(ctx, exchange, key, true, false, msg); err != nil {
+		p.mu.Lock()
+		delete(s.pending, seq)
+		delete(s.byID, pc.messageID)
+		p.mu.Unlock()
+		s.ch.Close()
+		p.session = nil
+		return nil, err
+	}
+	s.seq = seq
+	return pc, nil
+}
+
+// PublishWithContext publishes msg and waits for its confirmation. Mandatory
+// is always set; immediate is ignored.
+func (p *ConfirmingPublisher) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if _, ok := ctx.Deadline(); !ok && p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	pc, err := p.PublishAsync(ctx, exchange, key, msg)
+	if err != nil {
+		return err
+	}
+	return pc.Wait(ctx)
+}
+
+func (p *ConfirmingPublisher) Close() error {
+	p.publishMu.Lock()
+	defer p.publishMu.Unlock()
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	if p.session == nil {
+		return nil
+	}
+	return p.session.ch.Close()
+}
+
+func newMessageID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
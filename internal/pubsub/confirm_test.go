@@ -0,0 +1,111 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func declareWorkQueue(t *testing.T, ch Channel) {
+	t.Helper()
+	if err := ch.ExchangeDeclare("ex", amqp.ExchangeDirect, true, false, false, false, nil); err != nil {
+		t.Fatalf("ExchangeDeclare: %v", err)
+	}
+	if _, err := ch.QueueDeclare("work", true, false, false, false, nil); err != nil {
+		t.Fatalf("QueueDeclare: %v", err)
+	}
+	if err := ch.QueueBind("work", "work", "ex", false, nil); err != nil {
+		t.Fatalf("QueueBind: %v", err)
+	}
+}
+
+func TestConfirmingPublisherReportsUnroutable(t *testing.T) {
+	b := NewMemoryBroker()
+	defer b.Close()
+	declareWorkQueue(t, newTestChannel(t, b))
+	p, err := NewConfirmingPublisher(b, time.Second)
+	if err != nil {
+		t.Fatalf("NewConfirmingPublisher: %v", err)
+	}
+	defer p.Close()
+
+	if err := PublishJSON(p, "ex", "work", 1); err != nil {
+		t.Errorf("routable publish: %v", err)
+	}
+	var unroutable *UnroutableError
+	if err := PublishJSON(p, "ex", "nowhere", 1); !errors.As(err, &unroutable) {
+		t.Errorf("unroutable publish returned %v, want an UnroutableError", err)
+	}
+}
+
+func TestConfirmingPublisherFailsUnconfirmedOnChannelLoss(t *testing.T) {
+	b := NewMemoryBroker()
+	defer b.Close()
+	p, err := NewConfirmingPublisher(b, time.Second)
+	if err != nil {
+		t.Fatalf("NewConfirmingPublisher: %v", err)
+	}
+	defer p.Close()
+
+	// A publish the broker has not confirmed yet.
+	pc := &PendingConfirm{messageID: "in-flight", done: make(chan struct{})}
+	p.mu.Lock()
+	p.session.pending[1] = pc
+	p.session.byID[pc.messageID] = pc
+	p.mu.Unlock()
+
+	p.session.ch.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := pc.Wait(ctx); !errors.Is(err, ErrConfirmChannelLost) {
+		t.Errorf("Wait returned %v, want ErrConfirmChannelLost", err)
+	}
+}
+
+func TestConfirmingPublisherReconnects(t *testing.T) {
+	mc, dialer, b := newTestManagedConnection(t, ConnectionConfig{PublishBufferSize: 10})
+	inspect := newTestChannel(t, b)
+	declareWorkQueue(t, inspect)
+	p, err := NewConfirmingPublisher(mc, time.Second)
+	if err != nil {
+		t.Fatalf("NewConfirmingPublisher: %v", err)
+	}
+	defer p.Close()
+
+	if err := PublishJSON(p, "ex", "work", 1); err != nil {
+		t.Fatalf("publish before the outage: %v", err)
+	}
+
+	dialer.drop(true)
+	waitDown(t, mc)
+	// Confirmed publishes are not buffered, since nothing would confirm
+	// them once flushed.
+	start := time.Now()
+	if err := PublishJSON(p, "ex", "work", 2); err == nil {
+		t.Error("publish while disconnected succeeded")
+	}
+	if elapsed := time.Since(start); elapsed >= p.Timeout {
+		t.Errorf("publish while disconnected took %v, want it to fail without waiting for a confirm", elapsed)
+	}
+
+	dialer.setDown(false)
+	waitReady(t, mc)
+	if err := PublishJSON(p, "ex", "work", 3); err != nil {
+		t.Fatalf("publish after reconnecting: %v", err)
+	}
+
+	deliveries := consume(t, inspect, "work")
+	for _, want := range []string{"1", "3"} {
+		d := receive(t, deliveries)
+		if string(d.Body) != want {
+			t.Errorf("got %s, want %s", d.Body, want)
+		}
+		d.Ack(false)
+	}
+	if got := queueLength(t, inspect, "work"); got != 0 {
+		t.Errorf("work has %d more messages, want none", got)
+	}
+}
@@ -1,69 +1,240 @@
 package pubsub
 
 import (
-	"bytes"
-	"encoding/gob"
-	"encoding/json"
+	"context"
 	"fmt"
+	"log"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-func SubscribeJSON[T any](conn *amqp.Connection, exchange, queueName, key string, queueType SimpleQueueType, handler func(T) Acktype) error {
-	unmarshaller := func(body []byte) (T, error) {
-		var val T
-		err := json.Unmarshal(body, &val)
-		return val, err
-	}
-	return subscribe(conn, exchange, queueName, key, queueType, handler, unmarshaller)
+func SubscribeJSON[T any](broker Broker, exchange, queueName, key string, queueType SimpleQueueType, handler func(T) Acktype, opts ...SubscribeOption) error {
+	_, err := SubscribeJSONWithContext(context.Background(), broker, exchange, queueName, key, queueType, handler, opts...)
+	return err
+}
+
+func SubscribeGob[T any](broker Broker, exchange, queueName, key string, queueType SimpleQueueType, handler func(T) Acktype, opts ...SubscribeOption) error {
+	_, err := SubscribeGobWithContext(context.Background(), broker, exchange, queueName, key, queueType, handler, opts...)
+	return err
+}
+
+func SubscribeJSONWithContext[T any](ctx context.Context, broker Broker, exchange, queueName, key string, queueType SimpleQueueType, handler func(T) Acktype, opts ...SubscribeOption) (*Subscription, error) {
+	return subscribe(ctx, broker, exchange, queueName, key, queueType, valueHandler(handler), JSONCodec{}, opts)
+}
+
+func SubscribeGobWithContext[T any](ctx context.Context, broker Broker, exchange, queueName, key string, queueType SimpleQueueType, handler func(T) Acktype, opts ...SubscribeOption) (*Subscription, error) {
+	return subscribe(ctx, broker, exchange, queueName, key, queueType, valueHandler(handler), GobCodec{}, opts)
+}
+
+// Subscribe decodes each delivery with the codec registered for its
+// ContentType, so publishers can switch encodings without coordinating with
+// consumers.
+func Subscribe[T any](broker Broker, exchange, queueName, key string, queueType SimpleQueueType, handler func(T) Acktype, opts ...SubscribeOption) error {
+	_, err := SubscribeWithContext(context.Background(), broker, exchange, queueName, key, queueType, handler, opts...)
+	return err
+}
+
+func SubscribeWithContext[T any](ctx context.Context, broker Broker, exchange, queueName, key string, queueType SimpleQueueType, handler func(T) Acktype, opts ...SubscribeOption) (*Subscription, error) {
+	return subscribe(ctx, broker, exchange, queueName, key, queueType, valueHandler(handler), nil, opts)
+}
+
+// SubscribeDelivery is Subscribe for handlers that need the message envelope,
+// for example to reply with the same correlation ID.
+func SubscribeDelivery[T any](broker Broker, exchange, queueName, key string, queueType SimpleQueueType, handler func(Delivery[T]) Acktype, opts ...SubscribeOption) error {
+	_, err := SubscribeDeliveryWithContext(context.Background(), broker, exchange, queueName, key, queueType, handler, opts...)
+	return err
+}
+
+func SubscribeDeliveryWithContext[T any](ctx context.Context, broker Broker, exchange, queueName, key string, queueType SimpleQueueType, handler func(Delivery[T]) Acktype, opts ...SubscribeOption) (*Subscription, error) {
+	return subscribe(ctx, broker, exchange, queueName, key, queueType, handler, nil, opts)
 }
 
-func SubscribeGob[T any](conn *amqp.Connection, exchange, queueName, key string, queueType SimpleQueueType, handler func(T) Acktype) error {
-	unmarshaller := func(body []byte) (T, error) {
-		var val T
-		buffer := bytes.NewBuffer(body)
-		decoder := gob.NewDecoder(buffer)
-		err := decoder.Decode(&val)
-		return val, err
+func valueHandler[T any](handler func(T) Acktype) func(Delivery[T]) Acktype {
+	return func(d Delivery[T]) Acktype {
+		return handler(d.Value)
 	}
-	return subscribe(conn, exchange, queueName, key, queueType, handler, unmarshaller)
 }
 
-func subscribe[T any](conn *amqp.Connection, exchange, queueName, key string, queueType SimpleQueueType, handler func(T) Acktype, unmarshaller func([]byte) (T, error)) error {
-	ch, queue, err := DeclareAndBind(conn, exchange, queueName, key, queueType)
-	if err != nil {
-		return fmt.Errorf("Failed to declare and bind queue: %v", err)
+// subscribe decodes deliveries by their ContentType. fallback is used for
+// deliveries without one; SubscribeJSON and SubscribeGob set it so messages
+// from publishers that predate content types still decode.
+func subscribe[T any](ctx context.Context, broker Broker, exchange, queueName, key string, queueType SimpleQueueType, handler func(Delivery[T]) Acktype, fallback Codec, opts []SubscribeOption) (*Subscription, error) {
+	o := newSubscribeOptions(opts)
+	tag := o.consumerTag
+	if tag == "" {
+		tag = "peril-" + newMessageID()[:12]
 	}
-	if err := ch.Qos(10, 0, false); err != nil {
-		return fmt.Errorf("Failed to set QoS: %v", err)
+	boundQueue := queueName
+	start := func() (Channel, <-chan amqp.Delivery, error) {
+		ch, queue, err := DeclareAndBind(broker, exchange, queueName, key, queueType, opts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to declare and bind queue: %v", err)
+		}
+		if err := declareRetryTiers(ch, o.retry); err != nil {
+			ch.Close()
+			return nil, nil, err
+		}
+		if o.decodeFailure == DecodeFailureQuarantine {
+			if err := declareQuarantine(ch, queue.Name); err != nil {
+				ch.Close()
+				return nil, nil, err
+			}
+		}
+		boundQueue = queue.Name
+		if err := ch.Qos(o.prefetch(), 0, false); err != nil {
+			ch.Close()
+			return nil, nil, fmt.Errorf("Failed to set QoS: %v", err)
+		}
+		deliveries, err := ch.Consume(queue.Name, tag, false, o.exclusive, false, false, o.consumerArguments())
+		if err != nil {
+			ch.Close()
+			return nil, nil, fmt.Errorf("Failed to consume queue: %v", err)
+		}
+		return ch, deliveries, nil
 	}
-	deliveries, err := ch.Consume(queue.Name, "", false, false, false, false, nil)
+
+	ch, deliveries, err := start()
 	if err != nil {
-		return fmt.Errorf("Failed to consume queue: %v", err)
+		return nil, err
 	}
 
-	go func() {
-		defer ch.Close()
-		for delivery := range deliveries {
-			val, err := unmarshaller(delivery.Body)
+	ctx, cancel := context.WithCancel(ctx)
+	sub := &Subscription{
+		tag:    tag,
+		ch:     ch,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go sub.cancelOnDone(ctx)
+
+	h := Chain(typedHandler(handler), append([]Middleware{Recover()}, o.middleware...)...)
+	handle := func(delivery amqp.Delivery) {
+		deliveriesTotal.Inc(boundQueue)
+		if ctx.Err() != nil {
+			outcomesTotal.Inc(boundQueue, outcomeDrained)
+			delivery.Nack(false, true)
+			return
+		}
+		if o.dedup != nil && delivery.MessageId != "" {
+			seen, err := o.dedup.Seen(delivery.MessageId)
 			if err != nil {
-				fmt.Printf("Failed to unmarshal message: %v\n", err)
-				continue
+				fmt.Printf("Failed to check for duplicate message: %v\n", err)
+			} else if seen {
+				outcomesTotal.Inc(boundQueue, outcomeDuplicate)
+				delivery.Ack(false)
+				return
 			}
+		}
 
-			acktype := handler(val)
-			switch acktype {
-			case Ack:
-				delivery.Ack(false)
-			case NackRequeue:
-				delivery.Nack(false, true)
-			case NackDiscard:
-				delivery.Nack(false, false)
-			default:
-				delivery.Nack(false, false)
+		val, err := decode[T](o.codecs, fallback, delivery)
+		if err != nil {
+			fmt.Printf("Failed to unmarshal message: %v\n", err)
+			sub.poison.Add(1)
+			decodeFailuresTotal.Inc(boundQueue)
+			handlePoison(ch, delivery, boundQueue, err, o.decodeFailure)
+			return
+		}
+
+		env := envelopeFrom(delivery)
+		hctx := ContextWithCorrelationID(ctx, env.CorrelationID)
+		hctx, span := startConsumeSpan(hctx, env, boundQueue)
+		started := time.Now()
+		acktype := h(hctx, Delivery[any]{Envelope: env, Value: val, ctx: hctx})
+		observeHandler(boundQueue, acktype, time.Since(started))
+		span.SetAttribute("peril.acktype", acktype.String())
+		span.End()
+		if acktype == Ack && o.dedup != nil && delivery.MessageId != "" {
+			if err := o.dedup.Mark(delivery.MessageId); err != nil {
+				fmt.Printf("Failed to record handled message: %v\n", err)
+			}
+		}
+		switch acktype {
+		case Ack:
+			delivery.Ack(false)
+		case NackRequeue:
+			delivery.Nack(false, true)
+		case NackDiscard:
+			delivery.Nack(false, false)
+		case NackRetry:
+			retryDelivery(ch, delivery, boundQueue, o.retry)
+		default:
+			delivery.Nack(false, false)
+		}
+	}
+
+	go func() {
+		defer close(sub.done)
+		for {
+			// ch and boundQueue only change after dispatch has returned, so
+			// workers never see them change under them.
+			dispatch(deliveries, o.workers, o.prefetch(), o.orderingKey, handle)
+			sub.closeChannel()
+			if ctx.Err() != nil {
+				return
+			}
+
+			ch, deliveries = restart(ctx, broker, start)
+			if ch == nil {
+				return
+			}
+			sub.setChannel(ch)
+			if ctx.Err() != nil {
+				sub.cancelConsumer()
 			}
 		}
 	}()
 
-	return nil
+	return sub, nil
+}
+
+// reconnector is implemented by brokers that come back after losing their
+// connection, such as ManagedConnection.
+type reconnector interface {
+	Ready() <-chan struct{}
+	Done() <-chan struct{}
+}
+
+func restart(ctx context.Context, broker Broker, start func() (Channel, <-chan amqp.Delivery, error)) (Channel, <-chan amqp.Delivery) {
+	r, ok := broker.(reconnector)
+	if !ok {
+		return nil, nil
+	}
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-r.Done():
+			return nil, nil
+		case <-r.Ready():
+		}
+
+		ch, deliveries, err := start()
+		if err == nil {
+			return ch, deliveries
+		}
+		log.Printf("Failed to restart consumer: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-r.Done():
+			return nil, nil
+		case <-time.After(DefaultBackoff.Delay(attempt)):
+		}
+	}
+}
+
+func decode[T any](codecs *CodecRegistry, fallback Codec, delivery amqp.Delivery) (T, error) {
+	var val T
+	codec := fallback
+	if delivery.ContentType != "" || codec == nil {
+		c, err := codecs.Lookup(delivery.ContentType)
+		if err != nil {
+			return val, err
+		}
+		codec = c
+	}
+	err := codec.Unmarshal(delivery.Body, &val)
+	return val, err
 }
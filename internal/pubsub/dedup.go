@@ -0,0 +1,284 @@
+package pubsub
+
+import (
+	"bufio"
+	"container/list"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DedupStore remembers the IDs of messages that have been handled, so
+// redeliveries of the same message can be acked without running the handler
+// again.
+type DedupStore interface {
+	// Seen reports whether id was marked within the store's TTL.
+	Seen(id string) (bool, error)
+	Mark(id string) error
+}
+
+// MemoryDedupStore keeps up to capacity message IDs for ttl each, evicting the
+// oldest first.
+type MemoryDedupStore struct {
+	ttl      time.Duration
+	capacity int
+
+	mu    sync.Mutex
+	order *list.List
+	ids   map[string]*list.Element
+}
+
+type dedupEntry struct {
+	id     string
+	marked time.Time
+}
+
+func NewMemoryDedupStore(ttl time.Duration, capacity int) *MemoryDedupStore {
+	return &MemoryDedupStore{
+		ttl:      ttl,
+		capacity: capacity,
+		order:    list.New(),
+		ids:      map[string]*list.Element{},
+	}
+}
+
+func (s *MemoryDedupStore) Seen(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireLocked(time.Now())
+	_, ok := s.ids[id]
+	return ok, nil
+}
+
+func (s *MemoryDedupStore) Mark(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.markLocked(id, time.Now())
+	return nil
+}
+
+func (s *MemoryDedupStore) markLocked(id string, at time.Time) {
+	if s.ttl > 0 && time.Since(at) >= s.ttl {
+		return
+	}
+	if e, ok := s.ids[id]; ok {
+		s.order.Remove(e)
+	}
+	// Entries are kept in mark order so the oldest are evicted and expired
+	// first. Marks loaded from a file can arrive slightly out of order, which
+	// only delays their expiry.
+	s.ids[id] = s.order.PushBack(dedupEntry{id: id, marked: at})
+	for s.capacity > 0 && s.order.Len() > s.capacity {
+		s.removeLocked(s.order.Front())
+	}
+}
+
+func (s *MemoryDedupStore) expireLocked(now time.Time) {
+	if s.ttl <= 0 {
+		return
+	}
+	for e := s.order.Front(); e != nil; e = s.order.Front() {
+		if now.Sub(e.Value.(dedupEntry).marked) < s.ttl {
+			return
+		}
+		s.removeLocked(e)
+	}
+}
+
+func (s *MemoryDedupStore) removeLocked(e *list.Element) {
+	s.order.Remove(e)
+	delete(s.ids, e.Value.(dedupEntry).id)
+}
+
+// FileDedupStore is a MemoryDedupStore that appends every mark to a file, so
+// marks survive restarts and are shared by every process using the same
+// path, such as the servers started by multiserver.sh. Processes take turns
+// through a lock on path.lock, which is never replaced, so none appends to
+// a file another has just compacted away.
+type FileDedupStore struct {
+	mem  *MemoryDedupStore
+	path string
+	lock *os.File
+
+	mu     sync.Mutex
+	f      *os.File
+	offset int64
+	lines  int
+}
+
+// NewFileDedupStore opens or creates the file at path and loads the marks in
+// it that are still within ttl.
+func NewFileDedupStore(path string, ttl time.Duration, capacity int) (*FileDedupStore, error) {
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open dedup lock file: %v", err)
+	}
+	s := &FileDedupStore{
+		mem:  NewMemoryDedupStore(ttl, capacity),
+		path: path,
+		lock: lock,
+	}
+	unlock, err := s.lockFile(false)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	defer unlock()
+	if err := s.open(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileDedupStore) lockFile(exclusive bool) (func(), error) {
+	unlock, err := lockFile(s.lock, exclusive)
+	if err != nil {
+		return nil, fmt.Errorf("could not lock dedup file: %v", err)
+	}
+	return unlock, nil
+}
+
+func (s *FileDedupStore) Seen(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := s.lockFile(false)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	if err := s.syncLocked(); err != nil {
+		return false, err
+	}
+	return s.mem.Seen(id)
+}
+
+func (s *FileDedupStore) Mark(id string) error {
+	if strings.ContainsAny(id, "\t\n") {
+		return fmt.Errorf("invalid message id %q", id)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The exclusive lock keeps other processes from compacting the file
+	// between syncLocked finding the current one and the append to it.
+	unlock, err := s.lockFile(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := s.syncLocked(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	line := fmt.Sprintf("%s\t%d\n", id, now.UnixNano())
+	if _, err := s.f.WriteString(line); err != nil {
+		return fmt.Errorf("could not write dedup file: %v", err)
+	}
+	// Reading the line back marks id in memory.
+	if err := s.syncLocked(); err != nil {
+		return err
+	}
+
+	if s.mem.capacity > 0 && s.lines > 2*s.mem.capacity {
+		return s.compactLocked()
+	}
+	return nil
+}
+
+func (s *FileDedupStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lock.Close()
+	return s.f.Close()
+}
+
+func (s *FileDedupStore) open() error {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open dedup file: %v", err)
+	}
+	s.f = f
+	s.offset = 0
+	s.lines = 0
+	return s.syncLocked()
+}
+
+// syncLocked loads the marks other processes appended since the last call.
+// If another process compacted the file, it is reopened and read again. It
+// must be called with the file lock held.
+func (s *FileDedupStore) syncLocked() error {
+	current, err := os.Stat(s.path)
+	if err == nil {
+		opened, err := s.f.Stat()
+		if err == nil && !os.SameFile(current, opened) {
+			s.f.Close()
+			return s.open()
+		}
+	}
+
+	if _, err := s.f.Seek(s.offset, io.SeekStart); err != nil {
+		return fmt.Errorf("could not read dedup file: %v", err)
+	}
+	reader := bufio.NewReader(s.f)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			// A partial line is picked up once its writer finishes it.
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not read dedup file: %v", err)
+		}
+		s.offset += int64(len(line))
+		s.lines++
+
+		id, nanos, ok := strings.Cut(strings.TrimSuffix(line, "\n"), "\t")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(nanos, 10, 64)
+		if err != nil {
+			continue
+		}
+		s.mem.mu.Lock()
+		s.mem.markLocked(id, time.Unix(0, n))
+		s.mem.mu.Unlock()
+	}
+}
+
+// compactLocked rewrites the file with only the marks still in memory and
+// swaps it into place. It must be called with the exclusive file lock held,
+// after syncLocked has read every mark in the file.
+func (s *FileDedupStore) compactLocked() error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".dedup-*")
+	if err != nil {
+		return fmt.Errorf("could not compact dedup file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	s.mem.mu.Lock()
+	for e := s.mem.order.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(dedupEntry)
+		fmt.Fprintf(w, "%s\t%d\n", entry.id, entry.marked.UnixNano())
+	}
+	s.mem.mu.Unlock()
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not compact dedup file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not compact dedup file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("could not compact dedup file: %v", err)
+	}
+	s.f.Close()
+	return s.open()
+}
@@ -0,0 +1,164 @@
+package pubsub
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func seen(t *testing.T, store DedupStore, id string) bool {
+	t.Helper()
+	ok, err := store.Seen(id)
+	if err != nil {
+		t.Fatalf("Seen(%s): %v", id, err)
+	}
+	return ok
+}
+
+func mark(t *testing.T, store DedupStore, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		if err := store.Mark(id); err != nil {
+			t.Fatalf("Mark(%s): %v", id, err)
+		}
+	}
+}
+
+func newTestFileDedupStore(t *testing.T, path string, ttl time.Duration, capacity int) *FileDedupStore {
+	t.Helper()
+	store, err := NewFileDedupStore(path, ttl, capacity)
+	if err != nil {
+		t.Fatalf("NewFileDedupStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestDedupStoreExpiry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup")
+	for name, store := range map[string]DedupStore{
+		"memory": NewMemoryDedupStore(50*time.Millisecond, 10),
+		"file":   newTestFileDedupStore(t, path, 50*time.Millisecond, 10),
+	} {
+		t.Run(name, func(t *testing.T) {
+			mark(t, store, "a")
+			if !seen(t, store, "a") {
+				t.Fatal("a was not seen right after being marked")
+			}
+			time.Sleep(60 * time.Millisecond)
+			if seen(t, store, "a") {
+				t.Error("a was still seen after its TTL")
+			}
+		})
+	}
+}
+
+func TestDedupStoreCapacity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup")
+	for name, store := range map[string]DedupStore{
+		"memory": NewMemoryDedupStore(time.Hour, 2),
+		"file":   newTestFileDedupStore(t, path, time.Hour, 2),
+	} {
+		t.Run(name, func(t *testing.T) {
+			mark(t, store, "a", "b", "c")
+			if seen(t, store, "a") {
+				t.Error("the oldest mark was kept past capacity")
+			}
+			for _, id := range []string{"b", "c"} {
+				if !seen(t, store, id) {
+					t.Errorf("%s was evicted", id)
+				}
+			}
+		})
+	}
+}
+
+func TestFileDedupStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup")
+	store, err := NewFileDedupStore(path, time.Hour, 3)
+	if err != nil {
+		t.Fatalf("NewFileDedupStore: %v", err)
+	}
+	// Enough marks to compact the file on the way.
+	mark(t, store, "a", "b", "c", "d", "e", "f", "g")
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	reopened := newTestFileDedupStore(t, path, time.Hour, 3)
+	for _, id := range []string{"e", "f", "g"} {
+		if !seen(t, reopened, id) {
+			t.Errorf("%s was lost on reopening", id)
+		}
+	}
+	if seen(t, reopened, "a") {
+		t.Error("a was kept past capacity on reopening")
+	}
+}
+
+func TestFileDedupStoreSharedWhileMarking(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup")
+	// Each store stands in for a server process. The capacity holds every
+	// mark, so any missing at the end was lost rather than evicted.
+	stores := []*FileDedupStore{
+		newTestFileDedupStore(t, path, time.Hour, 5000),
+		newTestFileDedupStore(t, path, time.Hour, 5000),
+	}
+	const marks = 1100
+	var wg sync.WaitGroup
+	errs := make(chan error, len(stores))
+	for i, store := range stores {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < marks; n++ {
+				if err := store.Mark(fmt.Sprintf("%d-%d", i, n)); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	for _, store := range stores {
+		for i := range stores {
+			for n := 0; n < marks; n++ {
+				if id := fmt.Sprintf("%d-%d", i, n); !seen(t, store, id) {
+					t.Fatalf("mark %s was lost", id)
+				}
+			}
+		}
+	}
+}
+
+func TestFileDedupStoreSharedAcrossCompactions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup")
+	// The stores take turns, so the file is compacted by each in turn with
+	// the other's marks in it.
+	stores := []*FileDedupStore{
+		newTestFileDedupStore(t, path, time.Hour, 10),
+		newTestFileDedupStore(t, path, time.Hour, 10),
+	}
+	for n := 0; n < 100; n++ {
+		id := fmt.Sprintf("m-%d", n)
+		mark(t, stores[n%2], id)
+		if !seen(t, stores[(n+1)%2], id) {
+			t.Fatalf("mark %s was lost", id)
+		}
+	}
+	// Compacting keeps the most recent marks from both stores.
+	for n := 90; n < 100; n++ {
+		for _, store := range stores {
+			if id := fmt.Sprintf("m-%d", n); !seen(t, store, id) {
+				t.Errorf("mark %s was lost in compaction", id)
+			}
+		}
+	}
+}
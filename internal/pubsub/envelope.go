@@ -0,0 +1,175 @@
+package pubsub
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	HeaderSender        = "x-peril-sender"
+	HeaderSchemaVersion = "x-peril-schema-version"
+	HeaderTraceparent   = "traceparent"
+	HeaderTracestate    = "tracestate"
+)
+
+// DefaultSchemaVersion is stamped on messages whose type does not implement
+// Versioned.
+const DefaultSchemaVersion = 1
+
+// Versioned is implemented by message types that carry a schema version.
+type Versioned interface {
+	SchemaVersion() int
+}
+
+// Envelope is the metadata Peril attaches to every published message.
+type Envelope struct {
+	MessageID     string
+	CorrelationID string
+	Timestamp     time.Time
+	AppID         string
+	Sender        string
+	SchemaVersion int
+	ContentType   string
+	ReplyTo       string
+	Exchange      string
+	RoutingKey    string
+	Redelivered   bool
+	Headers       amqp.Table
+}
+
+// Delivery is a decoded message together with its envelope.
+type Delivery[T any] struct {
+	Envelope
+	Value T
+
+	ctx context.Context
+}
+
+// Context carries the delivery's correlation ID and the span its handler runs
+// in. Publishing with it makes the new message part of the same
+// conversation and trace.
+func (d Delivery[T]) Context() context.Context {
+	if d.ctx == nil {
+		return context.Background()
+	}
+	return d.ctx
+}
+
+func envelopeFrom(d amqp.Delivery) Envelope {
+	sender, _ := d.Headers[HeaderSender].(string)
+	version, ok := tableInt(d.Headers[HeaderSchemaVersion])
+	if !ok {
+		version = DefaultSchemaVersion
+	}
+	// Retries and poison handling republish a message through their own
+	// exchanges; its envelope still names where it was first published.
+	exchange, key := d.Exchange, d.RoutingKey
+	if original, ok := d.Headers[HeaderOriginalExchange].(string); ok {
+		exchange = original
+		key, _ = d.Headers[HeaderOriginalRoutingKey].(string)
+	}
+	return Envelope{
+		MessageID:     d.MessageId,
+		CorrelationID: d.CorrelationId,
+		Timestamp:     d.Timestamp,
+		AppID:         d.AppId,
+		Sender:        sender,
+		SchemaVersion: int(version),
+		ContentType:   d.ContentType,
+		ReplyTo:       d.ReplyTo,
+		Exchange:      exchange,
+		RoutingKey:    key,
+		Redelivered:   d.Redelivered,
+		Headers:       d.Headers,
+	}
+}
+
+func newEnvelope(ctx context.Context, val interface{}) amqp.Publishing {
+	version := DefaultSchemaVersion
+	if v, ok := val.(Versioned); ok {
+		version = v.SchemaVersion()
+	}
+	id := newMessageID()
+	correlationID, ok := CorrelationIDFromContext(ctx)
+	if !ok {
+		correlationID = id
+	}
+	appID, sender := processSender()
+	headers := amqp.Table{
+		HeaderSchemaVersion: int32(version),
+		HeaderSender:        sender,
+	}
+	injectTrace(ctx, headers)
+	return amqp.Publishing{
+		MessageId:     id,
+		CorrelationId: correlationID,
+		Timestamp:     time.Now().UTC(),
+		AppId:         appID,
+		Headers:       headers,
+	}
+}
+
+var (
+	senderMu sync.RWMutex
+	// Until SetSender is called, messages are sent as the program's name.
+	senderAppID = filepath.Base(os.Args[0])
+	senderName  = filepath.Base(os.Args[0])
+)
+
+// SetSender names the app and user every message published from then on
+// is sent by. WithSender overrides them for one publisher.
+func SetSender(appID, sender string) {
+	senderMu.Lock()
+	defer senderMu.Unlock()
+	senderAppID, senderName = appID, sender
+}
+
+func processSender() (appID, sender string) {
+	senderMu.RLock()
+	defer senderMu.RUnlock()
+	return senderAppID, senderName
+}
+
+type correlationKey struct{}
+
+// ContextWithCorrelationID makes messages published with ctx part of an
+// existing conversation. Without it each message starts a new one, using its
+// own ID as correlation ID.
+func ContextWithCorrelationID(ctx context.Context, correlationID string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, correlationID)
+}
+
+func CorrelationIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(correlationKey{}).(string)
+	return id, ok && id != ""
+}
+
+type senderPublisher struct {
+	Publisher
+	appID  string
+	sender string
+}
+
+// WithSender wraps pub so every message it publishes carries appID and the
+// sender's username, in place of the ones set with SetSender.
+func WithSender(pub Publisher, appID, sender string) Publisher {
+	return senderPublisher{Publisher: pub, appID: appID, sender: sender}
+}
+
+func (p senderPublisher) publishCodec() Codec { return codecOf(p.Publisher) }
+
+func (p senderPublisher) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	msg.AppId = p.appID
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[HeaderSender] = p.sender
+	msg.Headers = headers
+	return p.Publisher.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+}
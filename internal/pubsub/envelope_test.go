@@ -0,0 +1,63 @@
+package pubsub
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func publishAndReceive(t *testing.T, pub Publisher, ch Channel) amqp.Delivery {
+	t.Helper()
+	if err := PublishJSON(pub, "", "work", "hello"); err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+	return receive(t, consume(t, ch, "work"))
+}
+
+func TestEnvelopeStampsSender(t *testing.T) {
+	appID, sender := processSender()
+	t.Cleanup(func() { SetSender(appID, sender) })
+	program := filepath.Base(os.Args[0])
+	if appID != program || sender != program {
+		t.Errorf("default sender is %s/%s, want the program name %s", appID, sender, program)
+	}
+
+	tests := []struct {
+		name       string
+		publisher  func(Channel) Publisher
+		wantApp    string
+		wantSender string
+	}{
+		{
+			name:       "plain publish",
+			publisher:  func(ch Channel) Publisher { return ch },
+			wantApp:    "peril-client",
+			wantSender: "alice",
+		},
+		{
+			name:       "WithSender overrides",
+			publisher:  func(ch Channel) Publisher { return WithSender(ch, "peril-server", "server") },
+			wantApp:    "peril-server",
+			wantSender: "server",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewMemoryBroker()
+			defer b.Close()
+			ch := newTestChannel(t, b)
+			if _, err := ch.QueueDeclare("work", true, false, false, false, nil); err != nil {
+				t.Fatalf("QueueDeclare: %v", err)
+			}
+			SetSender("peril-client", "alice")
+
+			d := publishAndReceive(t, tt.publisher(ch), ch)
+			env := envelopeFrom(d)
+			if env.AppID != tt.wantApp || env.Sender != tt.wantSender {
+				t.Errorf("sent as %s/%s, want %s/%s", env.AppID, env.Sender, tt.wantApp, tt.wantSender)
+			}
+		})
+	}
+}
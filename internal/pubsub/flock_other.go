@@ -0,0 +1,11 @@
+//go:build !unix
+
+package pubsub
+
+import "os"
+
+// lockFile does nothing where flock is not available, so a FileDedupStore
+// there is only safe to use from one process.
+func lockFile(f *os.File, exclusive bool) (func(), error) {
+	return func() {}, nil
+}
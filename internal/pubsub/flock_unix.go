@@ -0,0 +1,27 @@
+//go:build unix
+
+package pubsub
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an advisory lock on f, shared by other processes' shared
+// locks unless exclusive, and returns the function that releases it.
+func lockFile(f *os.File, exclusive bool) (func(), error) {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return func() { syscall.Flock(int(f.Fd()), syscall.LOCK_UN) }, nil
+	}
+}
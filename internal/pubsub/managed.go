@@ -0,0 +1,476 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	ErrNotConnected       = errors.New("not connected to broker")
+	ErrPublishBufferFull  = errors.New("publish buffer full while disconnected")
+	ErrConnectionShutdown = errors.New("connection shut down")
+)
+
+type ConnectionConfig struct {
+	Backoff Backoff
+	// PublishBufferSize is the number of publishes held while disconnected and
+	// sent once the connection is restored. Zero makes those publishes fail
+	// with ErrNotConnected.
+	PublishBufferSize int
+}
+
+// ManagedConnection is a Broker over RabbitMQ that reconnects after the
+// connection is lost and re-declares every queue bound through DeclareAndBind.
+// Subscriptions started on it restart their consumers once it is back.
+type ManagedConnection struct {
+	dial func() (connection, error)
+	cfg  ConnectionConfig
+
+	mu           sync.Mutex
+	conn         connection
+	ready        chan struct{}
+	declarations []declaration
+	pending      []pendingPublish
+	shutdown     bool
+	done         chan struct{}
+}
+
+// connection is what a ManagedConnection dials: a RabbitMQ connection, or a
+// memory broker in tests.
+type connection interface {
+	channel() (rawChannel, error)
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+// rawChannel is a channel on one connection. Once closed it stays closed.
+type rawChannel interface {
+	confirmChannel
+	IsClosed() bool
+}
+
+type amqpConnection struct {
+	*amqp.Connection
+}
+
+func (c amqpConnection) channel() (rawChannel, error) {
+	ch, err := c.Channel()
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+type pendingPublish struct {
+	exchange  string
+	key       string
+	mandatory bool
+	immediate bool
+	msg       amqp.Publishing
+}
+
+// errReconnected is returned by buffer when the connection came back after
+// the caller found it down, so the publish should be sent instead.
+var errReconnected = errors.New("connection restored")
+
+// flushConfirmTimeout bounds the wait for the broker to confirm each
+// buffered publish flushed after reconnecting.
+const flushConfirmTimeout = 5 * time.Second
+
+func DialManaged(url string, cfg ConnectionConfig) (*ManagedConnection, error) {
+	return newManagedConnection(func() (connection, error) {
+		conn, err := amqp.Dial(url)
+		if err != nil {
+			return nil, err
+		}
+		return amqpConnection{conn}, nil
+	}, cfg)
+}
+
+func newManagedConnection(dial func() (connection, error), cfg ConnectionConfig) (*ManagedConnection, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	mc := &ManagedConnection{
+		dial:  dial,
+		cfg:   cfg,
+		conn:  conn,
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	close(mc.ready)
+	go mc.watch(conn.NotifyClose(make(chan *amqp.Error, 1)))
+	return mc, nil
+}
+
+func (mc *ManagedConnection) Channel() (Channel, error) {
+	ch := &managedChannel{mc: mc}
+	if _, err := ch.current(); err != nil && !errors.Is(err, ErrNotConnected) {
+		return nil, err
+	}
+	return ch, nil
+}
+
+func (mc *ManagedConnection) Close() error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.shutdown {
+		return nil
+	}
+	mc.shutdown = true
+	close(mc.done)
+	if mc.conn != nil {
+		return mc.conn.Close()
+	}
+	return nil
+}
+
+// Ready returns a channel that is closed while the connection is up.
+func (mc *ManagedConnection) Ready() <-chan struct{} {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.ready
+}
+
+// Done returns a channel that is closed once Close has been called.
+func (mc *ManagedConnection) Done() <-chan struct{} {
+	return mc.done
+}
+
+func (mc *ManagedConnection) recordDeclaration(d declaration) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	for _, existing := range mc.declarations {
+		if reflect.DeepEqual(existing, d) {
+			return
+		}
+	}
+	mc.declarations = append(mc.declarations, d)
+}
+
+func (mc *ManagedConnection) connection() (connection, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.shutdown {
+		return nil, ErrConnectionShutdown
+	}
+	if mc.conn == nil {
+		return nil, ErrNotConnected
+	}
+	return mc.conn, nil
+}
+
+func (mc *ManagedConnection) watch(closed <-chan *amqp.Error) {
+	select {
+	case <-mc.done:
+		return
+	case err := <-closed:
+		log.Printf("Lost connection to RabbitMQ: %v", err)
+	}
+
+	mc.mu.Lock()
+	if mc.shutdown {
+		mc.mu.Unlock()
+		return
+	}
+	mc.conn = nil
+	mc.ready = make(chan struct{})
+	mc.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-mc.done:
+			return
+		case <-time.After(mc.cfg.Backoff.Delay(attempt)):
+		}
+
+		conn, err := mc.dial()
+		if err != nil {
+			log.Printf("Reconnect attempt %d failed: %v", attempt+1, err)
+			continue
+		}
+		// Watch from before the connection is handed out, so a loss
+		// straight after restoring is not missed.
+		closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		if err := mc.restore(conn); err != nil {
+			log.Printf("Failed to restore topology: %v", err)
+			conn.Close()
+			continue
+		}
+		log.Printf("Reconnected to RabbitMQ")
+		go mc.watch(closed)
+		return
+	}
+}
+
+func (mc *ManagedConnection) restore(conn connection) error {
+	ch, err := conn.channel()
+	if err != nil {
+		return fmt.Errorf("Failed to open a channel: %v", err)
+	}
+	defer ch.Close()
+
+	mc.mu.Lock()
+	declarations := append([]declaration{}, mc.declarations...)
+	mc.mu.Unlock()
+	for _, d := range declarations {
+		if _, err := declareAndBindOn(ch, d.exchange, d.queueName, d.keys, d.queueType, d.args); err != nil {
+			return err
+		}
+	}
+
+	// Buffered publishes are flushed in confirm mode, and each leaves the
+	// buffer only once the broker has it. A flush cut short by another
+	// disconnect picks up where it stopped; a publish whose confirm was lost
+	// is sent again with the same message ID, for WithDedup to drop.
+	if err := ch.Confirm(false); err != nil {
+		return fmt.Errorf("Failed to enable confirm mode: %v", err)
+	}
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+	for {
+		// The lock is not held while publishing, so publishers only wait on
+		// the buffer, not the broker. They keep appending to it until the
+		// connection is marked up below, once it is empty.
+		mc.mu.Lock()
+		if mc.shutdown {
+			mc.mu.Unlock()
+			return ErrConnectionShutdown
+		}
+		if len(mc.pending) == 0 {
+			mc.conn = conn
+			close(mc.ready)
+			mc.mu.Unlock()
+			return nil
+		}
+		p := mc.pending[0]
+		mc.mu.Unlock()
+
+		if err := flushOne(ch, confirms, p); err != nil {
+			return fmt.Errorf("Failed to flush buffered publish: %v", err)
+		}
+
+		mc.mu.Lock()
+		mc.pending = mc.pending[1:]
+		mc.mu.Unlock()
+	}
+}
+
+func flushOne(ch Publisher, confirms <-chan amqp.Confirmation, p pendingPublish) error {
+	ctx, cancel := context.WithTimeout(context.Background(), flushConfirmTimeout)
+	defer cancel()
+	if err := ch.PublishWithContext(ctx, p.exchange, p.key, p.mandatory, p.immediate, p.msg); err != nil {
+		return err
+	}
+	select {
+	case conf, ok := <-confirms:
+		if !ok {
+			return ErrConfirmChannelLost
+		}
+		if !conf.Ack {
+			return ErrNacked
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (mc *ManagedConnection) buffer(p pendingPublish) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.shutdown {
+		return ErrConnectionShutdown
+	}
+	if mc.conn != nil {
+		return errReconnected
+	}
+	if mc.cfg.PublishBufferSize == 0 {
+		return ErrNotConnected
+	}
+	if len(mc.pending) >= mc.cfg.PublishBufferSize {
+		return ErrPublishBufferFull
+	}
+	if p.msg.MessageId == "" {
+		// Flushing may send it twice, so it needs an ID to be recognised by.
+		p.msg.MessageId = newMessageID()
+	}
+	mc.pending = append(mc.pending, p)
+	return nil
+}
+
+// managedChannel reopens its underlying channel on the current connection
+// whenever the previous one has been closed.
+type managedChannel struct {
+	mc *ManagedConnection
+
+	mu            sync.Mutex
+	ch            rawChannel
+	hasQos        bool
+	prefetchCount int
+	prefetchSize  int
+	global        bool
+	// confirming pins the channel: confirms and returns are registered on
+	// it, and a reopened channel would publish without them.
+	confirming bool
+	closed     bool
+}
+
+func (c *managedChannel) current() (rawChannel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+	if c.ch != nil && !c.ch.IsClosed() {
+		return c.ch, nil
+	}
+	if c.confirming {
+		return nil, ErrConfirmChannelLost
+	}
+	conn, err := c.mc.connection()
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.channel()
+	if err != nil {
+		return nil, err
+	}
+	if c.hasQos {
+		if err := ch.Qos(c.prefetchCount, c.prefetchSize, c.global); err != nil {
+			ch.Close()
+			return nil, err
+		}
+	}
+	c.ch = ch
+	return ch, nil
+}
+
+func (c *managedChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	for {
+		ch, err := c.current()
+		if errors.Is(err, ErrNotConnected) {
+			err = c.mc.buffer(pendingPublish{exchange, key, mandatory, immediate, msg})
+			if errors.Is(err, errReconnected) {
+				continue
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		return ch.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+	}
+}
+
+func (c *managedChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	ch, err := c.current()
+	if err != nil {
+		return err
+	}
+	if err := ch.Qos(prefetchCount, prefetchSize, global); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.hasQos = true
+	c.prefetchCount = prefetchCount
+	c.prefetchSize = prefetchSize
+	c.global = global
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *managedChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	ch, err := c.current()
+	if err != nil {
+		return nil, err
+	}
+	return ch.Consume(queue, consumer, autoAck, exclusive, noLocal, noWait, args)
+}
+
+func (c *managedChannel) Cancel(consumer string, noWait bool) error {
+	ch, err := c.current()
+	if err != nil {
+		return err
+	}
+	return ch.Cancel(consumer, noWait)
+}
+
+func (c *managedChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	ch, err := c.current()
+	if err != nil {
+		return err
+	}
+	return ch.ExchangeDeclare(name, kind, durable, autoDelete, internal, noWait, args)
+}
+
+func (c *managedChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	ch, err := c.current()
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+	return ch.QueueDeclare(name, durable, autoDelete, exclusive, noWait, args)
+}
+
+func (c *managedChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	ch, err := c.current()
+	if err != nil {
+		return err
+	}
+	return ch.QueueBind(name, key, exchange, noWait, args)
+}
+
+// Confirm puts the channel in confirm mode for good. From then on it is not
+// reopened: once the underlying channel is lost, every call fails with
+// ErrConfirmChannelLost and a new channel is needed.
+func (c *managedChannel) Confirm(noWait bool) error {
+	ch, err := c.current()
+	if err != nil {
+		return err
+	}
+	if err := ch.Confirm(noWait); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.confirming = true
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *managedChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	ch, err := c.current()
+	if err != nil {
+		close(confirm)
+		return confirm
+	}
+	return ch.NotifyPublish(confirm)
+}
+
+func (c *managedChannel) NotifyReturn(r chan amqp.Return) chan amqp.Return {
+	ch, err := c.current()
+	if err != nil {
+		close(r)
+		return r
+	}
+	return ch.NotifyReturn(r)
+}
+
+func (c *managedChannel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if c.ch != nil && !c.ch.IsClosed() {
+		return c.ch.Close()
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// memoryDialer connects ManagedConnections to a MemoryBroker. Dropping a
+// connection closes its channels as a lost RabbitMQ connection would, while
+// the broker, and its durable queues, stay up.
+type memoryDialer struct {
+	broker *MemoryBroker
+
+	mu    sync.Mutex
+	down  bool
+	conns []*memoryConnection
+}
+
+func (d *memoryDialer) dial() (connection, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.down {
+		return nil, errors.New("broker unreachable")
+	}
+	conn := &memoryConnection{broker: d.broker}
+	d.conns = append(d.conns, conn)
+	return conn, nil
+}
+
+// drop loses the current connection. With down set, reconnecting fails until
+// it is cleared with setDown(false).
+func (d *memoryDialer) drop(down bool) {
+	d.mu.Lock()
+	d.down = down
+	conn := d.conns[len(d.conns)-1]
+	d.mu.Unlock()
+	conn.shut(&amqp.Error{Code: amqp.ConnectionForced, Reason: "CONNECTION_FORCED - test"})
+}
+
+func (d *memoryDialer) setDown(down bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.down = down
+}
+
+type memoryConnection struct {
+	broker *MemoryBroker
+
+	mu       sync.Mutex
+	channels []Channel
+	notify   []chan *amqp.Error
+	closed   bool
+}
+
+func (c *memoryConnection) channel() (rawChannel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+	ch, err := c.broker.Channel()
+	if err != nil {
+		return nil, err
+	}
+	c.channels = append(c.channels, ch)
+	return ch.(rawChannel), nil
+}
+
+func (c *memoryConnection) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		close(receiver)
+		return receiver
+	}
+	c.notify = append(c.notify, receiver)
+	return receiver
+}
+
+func (c *memoryConnection) Close() error {
+	c.shut(nil)
+	return nil
+}
+
+func (c *memoryConnection) shut(reason *amqp.Error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	for _, ch := range c.channels {
+		ch.Close()
+	}
+	for _, n := range c.notify {
+		if reason != nil {
+			n <- reason
+		}
+		close(n)
+	}
+}
+
+var testBackoff = Backoff{Initial: time.Millisecond, Max: 10 * time.Millisecond, Multiplier: 2}
+
+func newTestManagedConnection(t *testing.T, cfg ConnectionConfig) (*ManagedConnection, *memoryDialer, *MemoryBroker) {
+	t.Helper()
+	b := NewMemoryBroker()
+	t.Cleanup(func() { b.Close() })
+	d := &memoryDialer{broker: b}
+	cfg.Backoff = testBackoff
+	mc, err := newManagedConnection(d.dial, cfg)
+	if err != nil {
+		t.Fatalf("newManagedConnection: %v", err)
+	}
+	t.Cleanup(func() { mc.Close() })
+	return mc, d, b
+}
+
+func waitReady(t *testing.T, mc *ManagedConnection) {
+	t.Helper()
+	select {
+	case <-mc.Ready():
+	case <-time.After(testTimeout):
+		t.Fatal("connection did not come back")
+	}
+}
+
+// waitDown waits for mc to notice its connection is gone.
+func waitDown(t *testing.T, mc *ManagedConnection) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		if _, err := mc.connection(); errors.Is(err, ErrNotConnected) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("connection loss was not noticed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitDials waits for n connections to have been dialed.
+func waitDials(t *testing.T, d *memoryDialer, n int) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		d.mu.Lock()
+		dialed := len(d.conns)
+		d.mu.Unlock()
+		if dialed >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("dialed %d connections, want %d", dialed, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestManagedConnectionFlushesBufferedPublishes(t *testing.T) {
+	mc, dialer, b := newTestManagedConnection(t, ConnectionConfig{PublishBufferSize: 10})
+	inspect := newTestChannel(t, b)
+	if err := inspect.ExchangeDeclare("ex", amqp.ExchangeDirect, true, false, false, false, nil); err != nil {
+		t.Fatalf("ExchangeDeclare: %v", err)
+	}
+	ch, _, err := DeclareAndBind(mc, "ex", "work", "work", QueueDurable)
+	if err != nil {
+		t.Fatalf("DeclareAndBind: %v", err)
+	}
+
+	dialer.drop(true)
+	waitDown(t, mc)
+	for _, body := range []string{"one", "two"} {
+		if err := PublishJSON(ch, "ex", "work", body); err != nil {
+			t.Fatalf("publishing while disconnected: %v", err)
+		}
+	}
+	if got := queueLength(t, inspect, "work"); got != 0 {
+		t.Fatalf("work has %d messages before reconnecting, want 0", got)
+	}
+
+	dialer.setDown(false)
+	waitReady(t, mc)
+	deliveries := consume(t, inspect, "work")
+	for _, want := range []string{`"one"`, `"two"`} {
+		d := receive(t, deliveries)
+		if string(d.Body) != want || d.MessageId == "" {
+			t.Errorf("flushed %s with message ID %q, want %s with an ID", d.Body, d.MessageId, want)
+		}
+		d.Ack(false)
+	}
+}
+
+func TestManagedConnectionBufferLimit(t *testing.T) {
+	mc, dialer, _ := newTestManagedConnection(t, ConnectionConfig{PublishBufferSize: 1})
+	ch, err := mc.Channel()
+	if err != nil {
+		t.Fatalf("Channel: %v", err)
+	}
+	dialer.drop(true)
+	waitDown(t, mc)
+
+	if err := PublishJSON(ch, "", "work", 1); err != nil {
+		t.Fatalf("first publish: %v", err)
+	}
+	if err := PublishJSON(ch, "", "work", 2); !errors.Is(err, ErrPublishBufferFull) {
+		t.Errorf("second publish returned %v, want ErrPublishBufferFull", err)
+	}
+}
+
+func TestManagedConnectionBufferAfterReconnect(t *testing.T) {
+	mc, _, _ := newTestManagedConnection(t, ConnectionConfig{PublishBufferSize: 10})
+	// The connection is up, as it is when it comes back between a publish
+	// finding it down and buffering.
+	err := mc.buffer(pendingPublish{key: "work"})
+	if !errors.Is(err, errReconnected) {
+		t.Fatalf("buffer returned %v, want errReconnected", err)
+	}
+	if len(mc.pending) != 0 {
+		t.Errorf("buffer kept %d publishes while connected", len(mc.pending))
+	}
+}
+
+func TestSubscriptionRestartsAfterReconnect(t *testing.T) {
+	mc, dialer, b := newTestManagedConnection(t, ConnectionConfig{})
+	publishCh := newTestChannel(t, b)
+	if err := publishCh.ExchangeDeclare("ex", amqp.ExchangeDirect, true, false, false, false, nil); err != nil {
+		t.Fatalf("ExchangeDeclare: %v", err)
+	}
+	got := make(chan int, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, err := SubscribeJSONWithContext(ctx, mc, "ex", "work", "work", QueueDurable, func(n int) Acktype {
+		got <- n
+		return Ack
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer func() {
+		cancel()
+		sub.Wait()
+	}()
+
+	dialer.drop(false)
+	waitDials(t, dialer, 2)
+	waitReady(t, mc)
+
+	if err := PublishJSON(publishCh, "ex", "work", 7); err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+	select {
+	case n := <-got:
+		if n != 7 {
+			t.Errorf("handler got %d, want 7", n)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("subscription did not restart")
+	}
+}
@@ -0,0 +1,794 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// MemoryBroker is an in-process Broker that mimics the parts of RabbitMQ
+// Peril relies on: direct, topic and fanout exchanges, durable and transient
+// queues, per-consumer prefetch, ack/nack/requeue, message TTLs and
+// dead-lettering. Exclusive queues are owned by the channel that declared
+// them and are deleted when it closes.
+type MemoryBroker struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	exchanges map[string]*memExchange
+	queues    map[string]*memQueue
+	channels  map[*memChannel]struct{}
+	seq       int
+	closed    bool
+}
+
+type memExchange struct {
+	name     string
+	kind     string
+	durable  bool
+	bindings []memBinding
+}
+
+type memBinding struct {
+	queue string
+	key   string
+}
+
+type memQueue struct {
+	name        string
+	durable     bool
+	autoDelete  bool
+	exclusive   bool
+	owner       *memChannel
+	args        amqp.Table
+	messages    []memMessage
+	consumers   int
+	hadConsumer bool
+
+	// With x-single-active-consumer only active receives deliveries; the
+	// standby consumers take over in the order they subscribed.
+	active  *memConsumer
+	standby []*memConsumer
+
+	exclusiveConsumer *memConsumer
+}
+
+func (q *memQueue) singleActiveConsumer() bool {
+	sac, _ := q.args["x-single-active-consumer"].(bool)
+	return sac
+}
+
+type memMessage struct {
+	exchange    string
+	key         string
+	publishing  amqp.Publishing
+	redelivered bool
+	expiresAt   time.Time
+}
+
+type memChannel struct {
+	broker     *MemoryBroker
+	prefetch   int
+	nextTag    uint64
+	unacked    map[uint64]*memUnacked
+	consumers  map[string]*memConsumer
+	confirming bool
+	publishSeq uint64
+	closed     bool
+
+	notifyMu     sync.Mutex
+	notifyClosed bool
+	confirms     []chan amqp.Confirmation
+	returns      []chan amqp.Return
+}
+
+type memUnacked struct {
+	queue    string
+	consumer *memConsumer
+	message  memMessage
+}
+
+type memConsumer struct {
+	tag        string
+	queue      string
+	autoAck    bool
+	ch         *memChannel
+	inflight   int
+	cancelled  bool
+	done       chan struct{}
+	deliveries chan amqp.Delivery
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	b := &MemoryBroker{
+		exchanges: map[string]*memExchange{},
+		queues:    map[string]*memQueue{},
+		channels:  map[*memChannel]struct{}{},
+	}
+	b.cond = sync.NewCond(&b.mu)
+	b.exchanges[""] = &memExchange{name: "", kind: amqp.ExchangeDirect, durable: true}
+	return b
+}
+
+func (b *MemoryBroker) Channel() (Channel, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, amqp.ErrClosed
+	}
+	ch := &memChannel{
+		broker:    b,
+		unacked:   map[uint64]*memUnacked{},
+		consumers: map[string]*memConsumer{},
+	}
+	b.channels[ch] = struct{}{}
+	return ch, nil
+}
+
+func (b *MemoryBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for ch := range b.channels {
+		b.closeChannelLocked(ch)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) closeChannelLocked(ch *memChannel) {
+	if ch.closed {
+		return
+	}
+	ch.closed = true
+	for _, c := range ch.consumers {
+		b.cancelConsumerLocked(c)
+	}
+
+	tags := make([]uint64, 0, len(ch.unacked))
+	for tag := range ch.unacked {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] > tags[j] })
+	for _, tag := range tags {
+		b.requeueLocked(ch.unacked[tag])
+		delete(ch.unacked, tag)
+	}
+
+	for name, q := range b.queues {
+		if q.exclusive && q.owner == ch {
+			b.deleteQueueLocked(name)
+		}
+	}
+	delete(b.channels, ch)
+	b.cond.Broadcast()
+
+	ch.notifyMu.Lock()
+	defer ch.notifyMu.Unlock()
+	ch.notifyClosed = true
+	for _, c := range ch.confirms {
+		close(c)
+	}
+	for _, c := range ch.returns {
+		close(c)
+	}
+}
+
+func (b *MemoryBroker) cancelConsumerLocked(c *memConsumer) {
+	if c.cancelled {
+		return
+	}
+	c.cancelled = true
+	close(c.done)
+	delete(c.ch.consumers, c.tag)
+	if q, ok := b.queues[c.queue]; ok {
+		q.consumers--
+		if q.exclusiveConsumer == c {
+			q.exclusiveConsumer = nil
+		}
+		if q.active == c {
+			q.active = nil
+			if len(q.standby) > 0 {
+				q.active, q.standby = q.standby[0], q.standby[1:]
+			}
+		} else {
+			for i, s := range q.standby {
+				if s == c {
+					q.standby = append(q.standby[:i], q.standby[i+1:]...)
+					break
+				}
+			}
+		}
+		if q.autoDelete && q.hadConsumer && q.consumers == 0 {
+			b.deleteQueueLocked(q.name)
+		}
+	}
+	b.cond.Broadcast()
+}
+
+func (b *MemoryBroker) deleteQueueLocked(name string) {
+	delete(b.queues, name)
+	for _, ex := range b.exchanges {
+		bindings := ex.bindings[:0]
+		for _, bnd := range ex.bindings {
+			if bnd.queue != name {
+				bindings = append(bindings, bnd)
+			}
+		}
+		ex.bindings = bindings
+	}
+	for ch := range b.channels {
+		for _, c := range ch.consumers {
+			if c.queue == name {
+				b.cancelConsumerLocked(c)
+			}
+		}
+	}
+}
+
+func (b *MemoryBroker) requeueLocked(u *memUnacked) {
+	if u.consumer != nil {
+		u.consumer.inflight--
+	}
+	q, ok := b.queues[u.queue]
+	if !ok {
+		return
+	}
+	msg := u.message
+	msg.redelivered = true
+	q.messages = append([]memMessage{msg}, q.messages...)
+	b.cond.Broadcast()
+}
+
+func (b *MemoryBroker) routeLocked(exchange, key string, msg amqp.Publishing) (int, error) {
+	ex, ok := b.exchanges[exchange]
+	if !ok {
+		return 0, &amqp.Error{Code: amqp.NotFound, Reason: fmt.Sprintf("NOT_FOUND - no exchange '%s'", exchange)}
+	}
+
+	targets := []string{}
+	seen := map[string]bool{}
+	if exchange == "" {
+		if _, ok := b.queues[key]; ok {
+			targets = append(targets, key)
+		}
+	}
+	for _, bnd := range ex.bindings {
+		if seen[bnd.queue] || !bindingMatches(ex.kind, bnd.key, key) {
+			continue
+		}
+		seen[bnd.queue] = true
+		targets = append(targets, bnd.queue)
+	}
+
+	for _, name := range targets {
+		q := b.queues[name]
+		m := memMessage{
+			exchange:   exchange,
+			key:        key,
+			publishing: msg,
+		}
+		if ttl, ok := tableInt(q.args["x-message-ttl"]); ok {
+			m.expiresAt = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+			time.AfterFunc(time.Duration(ttl)*time.Millisecond, func() {
+				b.mu.Lock()
+				defer b.mu.Unlock()
+				b.expireLocked(name)
+			})
+		}
+		q.messages = append(q.messages, m)
+		if max, ok := tableInt(q.args["x-max-length"]); ok {
+			for int64(len(q.messages)) > max {
+				dropped := q.messages[0]
+				q.messages = q.messages[1:]
+				b.deadLetterLocked(q, dropped, "maxlen")
+			}
+		}
+	}
+	if len(targets) > 0 {
+		b.cond.Broadcast()
+	}
+	return len(targets), nil
+}
+
+// expireLocked dead-letters expired messages at the head of a queue, which is
+// the only place RabbitMQ expires them from.
+func (b *MemoryBroker) expireLocked(name string) {
+	q, ok := b.queues[name]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	for len(q.messages) > 0 {
+		msg := q.messages[0]
+		if msg.expiresAt.IsZero() || now.Before(msg.expiresAt) {
+			return
+		}
+		q.messages = q.messages[1:]
+		b.deadLetterLocked(q, msg, "expired")
+	}
+}
+
+func (b *MemoryBroker) deadLetterLocked(q *memQueue, msg memMessage, reason string) {
+	dlx, ok := q.args["x-dead-letter-exchange"].(string)
+	if !ok {
+		return
+	}
+	key := msg.key
+	if dlk, ok := q.args["x-dead-letter-routing-key"].(string); ok {
+		key = dlk
+	}
+
+	pub := msg.publishing
+	headers := amqp.Table{}
+	for k, v := range pub.Headers {
+		headers[k] = v
+	}
+	headers["x-death"] = addDeath(headers["x-death"], q.name, reason, msg)
+	if _, ok := headers["x-first-death-queue"]; !ok {
+		headers["x-first-death-queue"] = q.name
+		headers["x-first-death-reason"] = reason
+		headers["x-first-death-exchange"] = msg.exchange
+	}
+	pub.Headers = headers
+
+	b.routeLocked(dlx, key, pub)
+}
+
+func addDeath(existing interface{}, queue, reason string, msg memMessage) []interface{} {
+	deaths, _ := existing.([]interface{})
+	for i, d := range deaths {
+		death, ok := d.(amqp.Table)
+		if !ok || death["queue"] != queue || death["reason"] != reason {
+			continue
+		}
+		updated := amqp.Table{}
+		for k, v := range death {
+			updated[k] = v
+		}
+		count, _ := updated["count"].(int64)
+		updated["count"] = count + 1
+		updated["time"] = time.Now()
+		out := append([]interface{}{updated}, deaths[:i]...)
+		return append(out, deaths[i+1:]...)
+	}
+	death := amqp.Table{
+		"count":        int64(1),
+		"reason":       reason,
+		"queue":        queue,
+		"time":         time.Now(),
+		"exchange":     msg.exchange,
+		"routing-keys": []interface{}{msg.key},
+	}
+	return append([]interface{}{death}, deaths...)
+}
+
+func bindingMatches(kind, pattern, key string) bool {
+	switch kind {
+	case amqp.ExchangeFanout:
+		return true
+	case amqp.ExchangeTopic:
+		return topicMatch(pattern, key)
+	default:
+		return pattern == key
+	}
+}
+
+func topicMatch(pattern, key string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(key, "."))
+}
+
+func matchWords(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if matchWords(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && matchWords(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && matchWords(pattern[1:], words[1:])
+	}
+}
+
+func (ch *memChannel) lock() error {
+	ch.broker.mu.Lock()
+	if ch.closed {
+		ch.broker.mu.Unlock()
+		return amqp.ErrClosed
+	}
+	return nil
+}
+
+func (ch *memChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := ch.lock(); err != nil {
+		return err
+	}
+	routed, err := ch.broker.routeLocked(exchange, key, msg)
+	if err != nil {
+		ch.broker.mu.Unlock()
+		return err
+	}
+	confirming := ch.confirming
+	if confirming {
+		ch.publishSeq++
+	}
+	seq := ch.publishSeq
+	ch.broker.mu.Unlock()
+
+	ch.notifyMu.Lock()
+	defer ch.notifyMu.Unlock()
+	if ch.notifyClosed {
+		return nil
+	}
+	if mandatory && routed == 0 {
+		ret := amqp.Return{
+			ReplyCode:       amqp.NoRoute,
+			ReplyText:       "NO_ROUTE",
+			Exchange:        exchange,
+			RoutingKey:      key,
+			ContentType:     msg.ContentType,
+			ContentEncoding: msg.ContentEncoding,
+			Headers:         msg.Headers,
+			DeliveryMode:    msg.DeliveryMode,
+			Priority:        msg.Priority,
+			CorrelationId:   msg.CorrelationId,
+			ReplyTo:         msg.ReplyTo,
+			Expiration:      msg.Expiration,
+			MessageId:       msg.MessageId,
+			Timestamp:       msg.Timestamp,
+			Type:            msg.Type,
+			UserId:          msg.UserId,
+			AppId:           msg.AppId,
+			Body:            msg.Body,
+		}
+		for _, c := range ch.returns {
+			c <- ret
+		}
+	}
+	if confirming {
+		for _, c := range ch.confirms {
+			c <- amqp.Confirmation{DeliveryTag: seq, Ack: true}
+		}
+	}
+	return nil
+}
+
+func (ch *memChannel) Confirm(noWait bool) error {
+	if err := ch.lock(); err != nil {
+		return err
+	}
+	defer ch.broker.mu.Unlock()
+	ch.confirming = true
+	return nil
+}
+
+func (ch *memChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	ch.notifyMu.Lock()
+	defer ch.notifyMu.Unlock()
+	if ch.notifyClosed {
+		close(confirm)
+		return confirm
+	}
+	ch.confirms = append(ch.confirms, confirm)
+	return confirm
+}
+
+func (ch *memChannel) NotifyReturn(c chan amqp.Return) chan amqp.Return {
+	ch.notifyMu.Lock()
+	defer ch.notifyMu.Unlock()
+	if ch.notifyClosed {
+		close(c)
+		return c
+	}
+	ch.returns = append(ch.returns, c)
+	return c
+}
+
+func (ch *memChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	if err := ch.lock(); err != nil {
+		return err
+	}
+	defer ch.broker.mu.Unlock()
+	switch kind {
+	case amqp.ExchangeDirect, amqp.ExchangeTopic, amqp.ExchangeFanout:
+	default:
+		return &amqp.Error{Code: amqp.CommandInvalid, Reason: fmt.Sprintf("COMMAND_INVALID - unknown exchange type '%s'", kind)}
+	}
+	if ex, ok := ch.broker.exchanges[name]; ok {
+		if ex.kind != kind || ex.durable != durable {
+			return &amqp.Error{Code: amqp.PreconditionFailed, Reason: fmt.Sprintf("PRECONDITION_FAILED - inequivalent arg for exchange '%s'", name)}
+		}
+		return nil
+	}
+	ch.broker.exchanges[name] = &memExchange{name: name, kind: kind, durable: durable}
+	return nil
+}
+
+func (ch *memChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	if err := ch.lock(); err != nil {
+		return amqp.Queue{}, err
+	}
+	defer ch.broker.mu.Unlock()
+	b := ch.broker
+
+	if name == "" {
+		b.seq++
+		name = fmt.Sprintf("amq.gen-%d", b.seq)
+	}
+	if q, ok := b.queues[name]; ok {
+		if q.exclusive && q.owner != ch {
+			return amqp.Queue{}, &amqp.Error{Code: amqp.ResourceLocked, Reason: fmt.Sprintf("RESOURCE_LOCKED - cannot obtain exclusive access to locked queue '%s'", name)}
+		}
+		if q.durable != durable {
+			return amqp.Queue{}, &amqp.Error{Code: amqp.PreconditionFailed, Reason: fmt.Sprintf("PRECONDITION_FAILED - inequivalent arg 'durable' for queue '%s'", name)}
+		}
+		return amqp.Queue{Name: name, Messages: len(q.messages), Consumers: q.consumers}, nil
+	}
+
+	queueArgs := amqp.Table{}
+	for k, v := range args {
+		queueArgs[k] = v
+	}
+	q := &memQueue{
+		name:       name,
+		durable:    durable,
+		autoDelete: autoDelete,
+		exclusive:  exclusive,
+		args:       queueArgs,
+	}
+	if exclusive {
+		q.owner = ch
+	}
+	b.queues[name] = q
+	return amqp.Queue{Name: name}, nil
+}
+
+func (ch *memChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	if err := ch.lock(); err != nil {
+		return err
+	}
+	defer ch.broker.mu.Unlock()
+	b := ch.broker
+
+	ex, ok := b.exchanges[exchange]
+	if !ok {
+		return &amqp.Error{Code: amqp.NotFound, Reason: fmt.Sprintf("NOT_FOUND - no exchange '%s'", exchange)}
+	}
+	if _, ok := b.queues[name]; !ok {
+		return &amqp.Error{Code: amqp.NotFound, Reason: fmt.Sprintf("NOT_FOUND - no queue '%s'", name)}
+	}
+	for _, bnd := range ex.bindings {
+		if bnd.queue == name && bnd.key == key {
+			return nil
+		}
+	}
+	ex.bindings = append(ex.bindings, memBinding{queue: name, key: key})
+	return nil
+}
+
+func (ch *memChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	if err := ch.lock(); err != nil {
+		return err
+	}
+	defer ch.broker.mu.Unlock()
+	ch.prefetch = prefetchCount
+	return nil
+}
+
+func (ch *memChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	if err := ch.lock(); err != nil {
+		return nil, err
+	}
+	defer ch.broker.mu.Unlock()
+	b := ch.broker
+
+	q, ok := b.queues[queue]
+	if !ok {
+		return nil, &amqp.Error{Code: amqp.NotFound, Reason: fmt.Sprintf("NOT_FOUND - no queue '%s'", queue)}
+	}
+	if q.exclusive && q.owner != ch {
+		return nil, &amqp.Error{Code: amqp.ResourceLocked, Reason: fmt.Sprintf("RESOURCE_LOCKED - cannot obtain exclusive access to locked queue '%s'", queue)}
+	}
+	if q.exclusiveConsumer != nil || exclusive && q.consumers > 0 {
+		return nil, &amqp.Error{Code: amqp.AccessRefused, Reason: fmt.Sprintf("ACCESS_REFUSED - queue '%s' in use", queue)}
+	}
+	if consumer == "" {
+		b.seq++
+		consumer = fmt.Sprintf("ctag-%d", b.seq)
+	}
+	if _, ok := ch.consumers[consumer]; ok {
+		return nil, &amqp.Error{Code: amqp.NotAllowed, Reason: fmt.Sprintf("NOT_ALLOWED - attempt to reuse consumer tag '%s'", consumer)}
+	}
+
+	c := &memConsumer{
+		tag:        consumer,
+		queue:      queue,
+		autoAck:    autoAck,
+		ch:         ch,
+		done:       make(chan struct{}),
+		deliveries: make(chan amqp.Delivery),
+	}
+	ch.consumers[consumer] = c
+	if exclusive {
+		q.exclusiveConsumer = c
+	}
+	if q.singleActiveConsumer() {
+		if q.active == nil {
+			q.active = c
+		} else {
+			q.standby = append(q.standby, c)
+		}
+	}
+	q.consumers++
+	q.hadConsumer = true
+	go c.run()
+	return c.deliveries, nil
+}
+
+func (ch *memChannel) Cancel(consumer string, noWait bool) error {
+	if err := ch.lock(); err != nil {
+		return err
+	}
+	defer ch.broker.mu.Unlock()
+	c, ok := ch.consumers[consumer]
+	if !ok {
+		return nil
+	}
+	ch.broker.cancelConsumerLocked(c)
+	return nil
+}
+
+func (ch *memChannel) Close() error {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+	ch.broker.closeChannelLocked(ch)
+	return nil
+}
+
+func (ch *memChannel) IsClosed() bool {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+	return ch.closed
+}
+
+func (ch *memChannel) Ack(tag uint64, multiple bool) error {
+	return ch.settle(tag, multiple, func(u *memUnacked) {
+		if u.consumer != nil {
+			u.consumer.inflight--
+		}
+	})
+}
+
+func (ch *memChannel) Nack(tag uint64, multiple bool, requeue bool) error {
+	b := ch.broker
+	return ch.settle(tag, multiple, func(u *memUnacked) {
+		if requeue {
+			b.requeueLocked(u)
+			return
+		}
+		if u.consumer != nil {
+			u.consumer.inflight--
+		}
+		if q, ok := b.queues[u.queue]; ok {
+			b.deadLetterLocked(q, u.message, "rejected")
+		}
+	})
+}
+
+func (ch *memChannel) Reject(tag uint64, requeue bool) error {
+	return ch.Nack(tag, false, requeue)
+}
+
+func (ch *memChannel) settle(tag uint64, multiple bool, fn func(*memUnacked)) error {
+	if err := ch.lock(); err != nil {
+		return err
+	}
+	defer ch.broker.mu.Unlock()
+
+	tags := []uint64{}
+	if multiple {
+		for t := range ch.unacked {
+			if t <= tag {
+				tags = append(tags, t)
+			}
+		}
+		sort.Slice(tags, func(i, j int) bool { return tags[i] > tags[j] })
+	} else if _, ok := ch.unacked[tag]; ok {
+		tags = append(tags, tag)
+	}
+	if len(tags) == 0 {
+		return &amqp.Error{Code: amqp.PreconditionFailed, Reason: fmt.Sprintf("PRECONDITION_FAILED - unknown delivery tag %d", tag)}
+	}
+	for _, t := range tags {
+		fn(ch.unacked[t])
+		delete(ch.unacked, t)
+	}
+	ch.broker.cond.Broadcast()
+	return nil
+}
+
+func (c *memConsumer) ready() bool {
+	c.ch.broker.expireLocked(c.queue)
+	q, ok := c.ch.broker.queues[c.queue]
+	if !ok || len(q.messages) == 0 {
+		return false
+	}
+	if q.singleActiveConsumer() && q.active != c {
+		return false
+	}
+	return c.autoAck || c.ch.prefetch <= 0 || c.inflight < c.ch.prefetch
+}
+
+func (c *memConsumer) run() {
+	defer close(c.deliveries)
+	b := c.ch.broker
+	for {
+		b.mu.Lock()
+		for !c.cancelled && !c.ready() {
+			b.cond.Wait()
+		}
+		if c.cancelled {
+			b.mu.Unlock()
+			return
+		}
+
+		q := b.queues[c.queue]
+		msg := q.messages[0]
+		q.messages = q.messages[1:]
+		c.ch.nextTag++
+		tag := c.ch.nextTag
+		if !c.autoAck {
+			c.ch.unacked[tag] = &memUnacked{queue: c.queue, consumer: c, message: msg}
+			c.inflight++
+		}
+		b.mu.Unlock()
+
+		pub := msg.publishing
+		delivery := amqp.Delivery{
+			Acknowledger:    c.ch,
+			Headers:         pub.Headers,
+			ContentType:     pub.ContentType,
+			ContentEncoding: pub.ContentEncoding,
+			DeliveryMode:    pub.DeliveryMode,
+			Priority:        pub.Priority,
+			CorrelationId:   pub.CorrelationId,
+			ReplyTo:         pub.ReplyTo,
+			Expiration:      pub.Expiration,
+			MessageId:       pub.MessageId,
+			Timestamp:       pub.Timestamp,
+			Type:            pub.Type,
+			UserId:          pub.UserId,
+			AppId:           pub.AppId,
+			ConsumerTag:     c.tag,
+			DeliveryTag:     tag,
+			Redelivered:     msg.redelivered,
+			Exchange:        msg.exchange,
+			RoutingKey:      msg.key,
+			Body:            pub.Body,
+		}
+		select {
+		case c.deliveries <- delivery:
+		case <-c.done:
+			if !c.autoAck {
+				c.ch.Nack(tag, false, true)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const testTimeout = 2 * time.Second
+
+func newTestChannel(t *testing.T, b Broker) Channel {
+	t.Helper()
+	ch, err := b.Channel()
+	if err != nil {
+		t.Fatalf("Channel: %v", err)
+	}
+	t.Cleanup(func() { ch.Close() })
+	return ch
+}
+
+// declareDLQ declares a fanout dead-letter exchange with one queue bound to
+// it, like peril_dlx and peril_dlq.
+func declareDLQ(t *testing.T, ch Channel, exchange, queue string) {
+	t.Helper()
+	if err := ch.ExchangeDeclare(exchange, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		t.Fatalf("ExchangeDeclare: %v", err)
+	}
+	if _, err := ch.QueueDeclare(queue, true, false, false, false, nil); err != nil {
+		t.Fatalf("QueueDeclare: %v", err)
+	}
+	if err := ch.QueueBind(queue, "", exchange, false, nil); err != nil {
+		t.Fatalf("QueueBind: %v", err)
+	}
+}
+
+func consume(t *testing.T, ch Channel, queue string) <-chan amqp.Delivery {
+	t.Helper()
+	deliveries, err := ch.Consume(queue, "", false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("Consume %s: %v", queue, err)
+	}
+	return deliveries
+}
+
+func receive(t *testing.T, deliveries <-chan amqp.Delivery) amqp.Delivery {
+	t.Helper()
+	select {
+	case d, ok := <-deliveries:
+		if !ok {
+			t.Fatal("deliveries closed")
+		}
+		return d
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for a delivery")
+	}
+	return amqp.Delivery{}
+}
+
+func queueLength(t *testing.T, ch Channel, queue string) int {
+	t.Helper()
+	q, err := ch.QueueDeclare(queue, true, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("QueueDeclare %s: %v", queue, err)
+	}
+	return q.Messages
+}
+
+func publishBody(t *testing.T, ch Channel, exchange, key, body string) {
+	t.Helper()
+	err := ch.PublishWithContext(context.Background(), exchange, key, false, false, amqp.Publishing{Body: []byte(body)})
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+}
+
+func TestTopicMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{"army_moves.*", "army_moves.bob", true},
+		{"army_moves.*", "army_moves", false},
+		{"army_moves.*", "army_moves.bob.x", false},
+		{"war.*.*", "war.alice.bob", true},
+		{"war.*.*", "war.alice", false},
+		{"game_logs.#", "game_logs", true},
+		{"game_logs.#", "game_logs.bob.x", true},
+		{"#", "anything.at.all", true},
+		{"#.bob", "war.alice.bob", true},
+		{"#.bob", "war.bob.alice", false},
+		{"war_results.*.bob", "war_results.alice.bob", true},
+		{"war_results.alice.*", "war_results.bob.alice", false},
+		{"pause", "pause", true},
+		{"pause", "paused", false},
+	}
+	for _, tt := range tests {
+		if got := topicMatch(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("topicMatch(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryBrokerRoutesByExchangeKind(t *testing.T) {
+	b := NewMemoryBroker()
+	defer b.Close()
+	ch := newTestChannel(t, b)
+
+	for _, ex := range []struct{ name, kind string }{
+		{"direct", amqp.ExchangeDirect},
+		{"topic", amqp.ExchangeTopic},
+		{"fanout", amqp.ExchangeFanout},
+	} {
+		if err := ch.ExchangeDeclare(ex.name, ex.kind, true, false, false, false, nil); err != nil {
+			t.Fatalf("ExchangeDeclare %s: %v", ex.name, err)
+		}
+	}
+	for _, bnd := range []struct{ queue, key, exchange string }{
+		{"direct_q", "pause", "direct"},
+		{"star_q", "army_moves.*", "topic"},
+		{"hash_q", "army_moves.#", "topic"},
+		{"fanout_q", "ignored", "fanout"},
+	} {
+		if _, err := ch.QueueDeclare(bnd.queue, true, false, false, false, nil); err != nil {
+			t.Fatalf("QueueDeclare %s: %v", bnd.queue, err)
+		}
+		if err := ch.QueueBind(bnd.queue, bnd.key, bnd.exchange, false, nil); err != nil {
+			t.Fatalf("QueueBind %s: %v", bnd.queue, err)
+		}
+	}
+
+	publishBody(t, ch, "direct", "pause", "1")
+	publishBody(t, ch, "direct", "resume", "2")
+	publishBody(t, ch, "topic", "army_moves.bob", "3")
+	publishBody(t, ch, "topic", "army_moves", "4")
+	publishBody(t, ch, "topic", "army_moves.bob.extra", "5")
+	publishBody(t, ch, "topic", "war.bob", "6")
+	publishBody(t, ch, "fanout", "anything", "7")
+	// The default exchange routes by queue name.
+	publishBody(t, ch, "", "direct_q", "8")
+
+	want := map[string]int{"direct_q": 2, "star_q": 1, "hash_q": 3, "fanout_q": 1}
+	for queue, n := range want {
+		if got := queueLength(t, ch, queue); got != n {
+			t.Errorf("%s has %d messages, want %d", queue, got, n)
+		}
+	}
+
+	err := ch.PublishWithContext(context.Background(), "missing", "k", false, false, amqp.Publishing{})
+	var amqpErr *amqp.Error
+	if !errors.As(err, &amqpErr) || amqpErr.Code != amqp.NotFound {
+		t.Errorf("publishing to a missing exchange returned %v, want NOT_FOUND", err)
+	}
+}
+
+func TestMemoryBrokerDeadLettersRejectedMessages(t *testing.T) {
+	b := NewMemoryBroker()
+	defer b.Close()
+	ch := newTestChannel(t, b)
+	declareDLQ(t, ch, "dlx", "dlq")
+	args := amqp.Table{"x-dead-letter-exchange": "dlx"}
+	if _, err := ch.QueueDeclare("work", true, false, false, false, args); err != nil {
+		t.Fatalf("QueueDeclare: %v", err)
+	}
+
+	publishBody(t, ch, "", "work", "poison")
+	d := receive(t, consume(t, ch, "work"))
+	if err := d.Nack(false, false); err != nil {
+		t.Fatalf("Nack: %v", err)
+	}
+
+	dead := receive(t, consume(t, ch, "dlq"))
+	if string(dead.Body) != "poison" {
+		t.Errorf("dead-lettered body = %q, want poison", dead.Body)
+	}
+	if got := dead.Headers["x-first-death-reason"]; got != "rejected" {
+		t.Errorf("x-first-death-reason = %v, want rejected", got)
+	}
+	deaths, _ := dead.Headers["x-death"].([]interface{})
+	if len(deaths) != 1 || deaths[0].(amqp.Table)["queue"] != "work" {
+		t.Errorf("x-death = %v, want one death in work", deaths)
+	}
+}
+
+func TestMemoryBrokerDeadLettersExpiredMessages(t *testing.T) {
+	b := NewMemoryBroker()
+	defer b.Close()
+	ch := newTestChannel(t, b)
+	declareDLQ(t, ch, "dlx", "dlq")
+	args := amqp.Table{
+		"x-message-ttl":          int64(20),
+		"x-dead-letter-exchange": "dlx",
+	}
+	if _, err := ch.QueueDeclare("delay", true, false, false, false, args); err != nil {
+		t.Fatalf("QueueDeclare: %v", err)
+	}
+
+	publishBody(t, ch, "", "delay", "late")
+	start := time.Now()
+	dead := receive(t, consume(t, ch, "dlq"))
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("message expired after %v, before its 20ms TTL", elapsed)
+	}
+	if got := dead.Headers["x-first-death-reason"]; got != "expired" {
+		t.Errorf("x-first-death-reason = %v, want expired", got)
+	}
+	if got := queueLength(t, ch, "delay"); got != 0 {
+		t.Errorf("delay queue still has %d messages", got)
+	}
+}
+
+func TestMemoryBrokerRequeuesAtTheFront(t *testing.T) {
+	b := NewMemoryBroker()
+	defer b.Close()
+	ch := newTestChannel(t, b)
+	if _, err := ch.QueueDeclare("work", true, false, false, false, nil); err != nil {
+		t.Fatalf("QueueDeclare: %v", err)
+	}
+	publishBody(t, ch, "", "work", "first")
+	publishBody(t, ch, "", "work", "second")
+
+	if err := ch.Qos(1, 0, false); err != nil {
+		t.Fatalf("Qos: %v", err)
+	}
+	deliveries := consume(t, ch, "work")
+	d := receive(t, deliveries)
+	if string(d.Body) != "first" || d.Redelivered {
+		t.Fatalf("got %q redelivered=%v, want first delivery of first", d.Body, d.Redelivered)
+	}
+	if err := d.Nack(false, true); err != nil {
+		t.Fatalf("Nack: %v", err)
+	}
+
+	d = receive(t, deliveries)
+	if string(d.Body) != "first" || !d.Redelivered {
+		t.Fatalf("got %q redelivered=%v, want first redelivered", d.Body, d.Redelivered)
+	}
+	d.Ack(false)
+	d = receive(t, deliveries)
+	if string(d.Body) != "second" {
+		t.Fatalf("got %q, want second", d.Body)
+	}
+	d.Ack(false)
+}
+
+func TestMemoryBrokerRequeuesUnackedOnClose(t *testing.T) {
+	b := NewMemoryBroker()
+	defer b.Close()
+	ch := newTestChannel(t, b)
+	if _, err := ch.QueueDeclare("work", true, false, false, false, nil); err != nil {
+		t.Fatalf("QueueDeclare: %v", err)
+	}
+	publishBody(t, ch, "", "work", "held")
+
+	consumerCh := newTestChannel(t, b)
+	receive(t, consume(t, consumerCh, "work"))
+	consumerCh.Close()
+
+	d := receive(t, consume(t, ch, "work"))
+	if string(d.Body) != "held" || !d.Redelivered {
+		t.Errorf("got %q redelivered=%v, want held redelivered", d.Body, d.Redelivered)
+	}
+}
+
+func TestMemoryBrokerExclusiveQueues(t *testing.T) {
+	b := NewMemoryBroker()
+	defer b.Close()
+	owner := newTestChannel(t, b)
+	other := newTestChannel(t, b)
+
+	q, err := owner.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		t.Fatalf("QueueDeclare: %v", err)
+	}
+	if q.Name == "" {
+		t.Fatal("server-named queue has no name")
+	}
+
+	var amqpErr *amqp.Error
+	if _, err := other.QueueDeclare(q.Name, false, true, true, false, nil); !errors.As(err, &amqpErr) || amqpErr.Code != amqp.ResourceLocked {
+		t.Errorf("redeclaring another channel's exclusive queue returned %v, want RESOURCE_LOCKED", err)
+	}
+	if _, err := other.Consume(q.Name, "", false, false, false, false, nil); !errors.As(err, &amqpErr) || amqpErr.Code != amqp.ResourceLocked {
+		t.Errorf("consuming another channel's exclusive queue returned %v, want RESOURCE_LOCKED", err)
+	}
+
+	owner.Close()
+	if _, err := other.QueueDeclare(q.Name, false, true, true, false, nil); err != nil {
+		t.Errorf("queue was not deleted with its owner: %v", err)
+	}
+}
+
+func TestMemoryBrokerExclusiveConsumer(t *testing.T) {
+	b := NewMemoryBroker()
+	defer b.Close()
+	ch := newTestChannel(t, b)
+	if _, err := ch.QueueDeclare("work", true, false, false, false, nil); err != nil {
+		t.Fatalf("QueueDeclare: %v", err)
+	}
+	if _, err := ch.Consume("work", "first", false, true, false, false, nil); err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	var amqpErr *amqp.Error
+	if _, err := ch.Consume("work", "second", false, false, false, false, nil); !errors.As(err, &amqpErr) || amqpErr.Code != amqp.AccessRefused {
+		t.Errorf("second consumer returned %v, want ACCESS_REFUSED", err)
+	}
+	if err := ch.Cancel("first", false); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	if _, err := ch.Consume("work", "second", false, false, false, false, nil); err != nil {
+		t.Errorf("consumer after the exclusive one was cancelled: %v", err)
+	}
+}
+
+func TestMemoryBrokerMaxLength(t *testing.T) {
+	b := NewMemoryBroker()
+	defer b.Close()
+	ch := newTestChannel(t, b)
+	if _, err := ch.QueueDeclare("latest", true, false, false, false, amqp.Table{"x-max-length": int32(1)}); err != nil {
+		t.Fatalf("QueueDeclare: %v", err)
+	}
+	publishBody(t, ch, "", "latest", "old")
+	publishBody(t, ch, "", "latest", "new")
+
+	if got := queueLength(t, ch, "latest"); got != 1 {
+		t.Fatalf("queue has %d messages, want 1", got)
+	}
+	if d := receive(t, consume(t, ch, "latest")); string(d.Body) != "new" {
+		t.Errorf("kept %q, want new", d.Body)
+	}
+}
+
+type testMove struct {
+	Player string
+	To     string
+}
+
+func TestSubscribeRoundTrip(t *testing.T) {
+	b := NewMemoryBroker()
+	defer b.Close()
+	ch := newTestChannel(t, b)
+	if err := ch.ExchangeDeclare("peril_topic", amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+		t.Fatalf("ExchangeDeclare: %v", err)
+	}
+
+	got := make(chan Delivery[testMove], 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, err := SubscribeDeliveryWithContext(ctx, b, "peril_topic", "army_moves.alice", "army_moves.*", QueueTransient, func(d Delivery[testMove]) Acktype {
+		got <- d
+		return Ack
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer func() {
+		cancel()
+		sub.Wait()
+	}()
+
+	want := testMove{Player: "bob", To: "europe"}
+	if err := PublishJSON(ch, "peril_topic", "army_moves.bob", want); err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+	select {
+	case d := <-got:
+		if d.Value != want {
+			t.Errorf("handler got %+v, want %+v", d.Value, want)
+		}
+		if d.RoutingKey != "army_moves.bob" || d.MessageID == "" {
+			t.Errorf("envelope = %+v, want routing key army_moves.bob and a message ID", d.Envelope)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("handler was not called")
+	}
+}
@@ -0,0 +1,54 @@
+package pubsub
+
+import (
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/metrics"
+)
+
+var (
+	publishesTotal = metrics.Default.NewCounterVec(
+		"peril_publishes_total",
+		"Messages published, by exchange, routing key and result.",
+		"exchange", "routing_key", "result",
+	)
+	deliveriesTotal = metrics.Default.NewCounterVec(
+		"peril_deliveries_total",
+		"Deliveries received, by queue.",
+		"queue",
+	)
+	outcomesTotal = metrics.Default.NewCounterVec(
+		"peril_delivery_outcomes_total",
+		"Deliveries settled, by queue and outcome.",
+		"queue", "outcome",
+	)
+	decodeFailuresTotal = metrics.Default.NewCounterVec(
+		"peril_decode_failures_total",
+		"Deliveries whose body could not be decoded, by queue.",
+		"queue",
+	)
+	handlerSeconds = metrics.Default.NewHistogramVec(
+		"peril_handler_duration_seconds",
+		"Time handlers took to settle a delivery, by queue.",
+		metrics.DefaultBuckets,
+		"queue",
+	)
+)
+
+const (
+	outcomeDuplicate = "duplicate"
+	outcomeDrained   = "drained"
+)
+
+func observePublish(exchange, key string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	publishesTotal.Inc(exchange, key, result)
+}
+
+func observeHandler(queue string, acktype Acktype, elapsed time.Duration) {
+	outcomesTotal.Inc(queue, acktype.String())
+	handlerSeconds.Observe(elapsed.Seconds(), queue)
+}
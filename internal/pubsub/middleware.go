@@ -0,0 +1,202 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Handler is the untyped form of a subscription's handler that middleware
+// wraps. Value holds the decoded message.
+type Handler func(ctx context.Context, d Delivery[any]) Acktype
+
+// Middleware wraps a Handler with behaviour shared across subscriptions.
+type Middleware func(Handler) Handler
+
+// Chain applies mws to h so that the first middleware is the outermost.
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+func typedHandler[T any](handler func(Delivery[T]) Acktype) Handler {
+	return func(ctx context.Context, d Delivery[any]) Acktype {
+		val, _ := d.Value.(T)
+		return handler(Delivery[T]{Envelope: d.Envelope, Value: val, ctx: ctx})
+	}
+}
+
+// Recover settles deliveries whose handler panics with NackDiscard, so the
+// message is dead-lettered instead of killing the consumer. Subscriptions
+// always install it outside any other middleware.
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, d Delivery[any]) (acktype Acktype) {
+			defer func() {
+				if r := recover(); r != nil {
+					fmt.Printf("Handler panicked on message %s: %v\n%s", d.MessageID, r, debug.Stack())
+					acktype = NackDiscard
+				}
+			}()
+			return next(ctx, d)
+		}
+	}
+}
+
+// Logging logs every delivery's envelope, how it was settled and how long the
+// handler took.
+func Logging(logger *slog.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, d Delivery[any]) Acktype {
+			start := time.Now()
+			acktype := next(ctx, d)
+			logger.InfoContext(ctx, "handled message",
+				"message_id", d.MessageID,
+				"correlation_id", d.CorrelationID,
+				"exchange", d.Exchange,
+				"routing_key", d.RoutingKey,
+				"sender", d.Sender,
+				"redelivered", d.Redelivered,
+				"acktype", acktype.String(),
+				"duration", time.Since(start),
+			)
+			return acktype
+		}
+	}
+}
+
+// Timeout cancels the handler's context if it has not returned within d, and
+// settles the delivery with onTimeout. It still waits for the handler to
+// return first, so a requeued message never runs alongside its timed out
+// attempt; handlers should give up once their context is done. A handler
+// that finishes with Ack regardless has done its work, so Ack stands.
+func Timeout(d time.Duration, onTimeout Acktype) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, delivery Delivery[any]) Acktype {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+
+			type result struct {
+				acktype Acktype
+				panic   interface{}
+			}
+			done := make(chan result, 1)
+			go func() {
+				// Re-panic on the consumer's goroutine so Recover sees it.
+				defer func() {
+					if r := recover(); r != nil {
+						done <- result{panic: r}
+					}
+				}()
+				done <- result{acktype: next(ctx, delivery)}
+			}()
+
+			timedOut := false
+			var r result
+			select {
+			case r = <-done:
+			case <-ctx.Done():
+				fmt.Printf("Handler for message %s timed out after %v\n", delivery.MessageID, d)
+				timedOut = true
+				r = <-done
+			}
+			if r.panic != nil {
+				panic(r.panic)
+			}
+			if timedOut && r.acktype != Ack {
+				return onTimeout
+			}
+			return r.acktype
+		}
+	}
+}
+
+// Latency reports how long each handler took and how it settled the
+// delivery.
+func Latency(observe func(e Envelope, acktype Acktype, elapsed time.Duration)) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, d Delivery[any]) Acktype {
+			start := time.Now()
+			acktype := next(ctx, d)
+			observe(d.Envelope, acktype, time.Since(start))
+			return acktype
+		}
+	}
+}
+
+// PublisherFunc adapts a function to the Publisher interface.
+type PublisherFunc func(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+
+func (f PublisherFunc) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	return f(ctx, exchange, key, mandatory, immediate, msg)
+}
+
+// PublishMiddleware wraps a Publisher with behaviour shared across
+// publishers.
+type PublishMiddleware func(Publisher) Publisher
+
+// ChainPublisher applies mws to pub so that the first middleware is the
+// outermost. The chain keeps the codec pub was given with WithCodec.
+func ChainPublisher(pub Publisher, mws ...PublishMiddleware) Publisher {
+	chained := pub
+	for i := len(mws) - 1; i >= 0; i-- {
+		chained = mws[i](chained)
+	}
+	if _, ok := pub.(codecCarrier); ok {
+		return WithCodec(chained, codecOf(pub))
+	}
+	return chained
+}
+
+// PublishLogging logs every publish and whether it failed.
+func PublishLogging(logger *slog.Logger) PublishMiddleware {
+	return func(next Publisher) Publisher {
+		return PublisherFunc(func(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+			start := time.Now()
+			err := next.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+			attrs := []interface{}{
+				"message_id", msg.MessageId,
+				"correlation_id", msg.CorrelationId,
+				"exchange", exchange,
+				"routing_key", key,
+				"duration", time.Since(start),
+			}
+			if err != nil {
+				logger.ErrorContext(ctx, "publish failed", append(attrs, "error", err)...)
+			} else {
+				logger.InfoContext(ctx, "published message", attrs...)
+			}
+			return err
+		})
+	}
+}
+
+// PublishTimeout bounds each publish, including waiting for a confirm when
+// the wrapped Publisher is a ConfirmingPublisher.
+func PublishTimeout(d time.Duration) PublishMiddleware {
+	return func(next Publisher) Publisher {
+		return PublisherFunc(func(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			return next.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+		})
+	}
+}
+
+// PublishLatency reports how long each publish took and its error, if any.
+func PublishLatency(observe func(exchange, key string, err error, elapsed time.Duration)) PublishMiddleware {
+	return func(next Publisher) Publisher {
+		return PublisherFunc(func(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+			start := time.Now()
+			err := next.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+			observe(exchange, key, err, time.Since(start))
+			return err
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package pubsub
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler Handler
+		want    Acktype
+	}{
+		{
+			name: "handler in time",
+			handler: func(ctx context.Context, d Delivery[any]) Acktype {
+				return NackDiscard
+			},
+			want: NackDiscard,
+		},
+		{
+			name: "handler gives up",
+			handler: func(ctx context.Context, d Delivery[any]) Acktype {
+				<-ctx.Done()
+				return NackDiscard
+			},
+			want: NackRequeue,
+		},
+		{
+			name: "handler finishes late",
+			handler: func(ctx context.Context, d Delivery[any]) Acktype {
+				time.Sleep(30 * time.Millisecond)
+				return Ack
+			},
+			want: Ack,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Timeout(10*time.Millisecond, NackRequeue)(tt.handler)
+			if got := h(context.Background(), Delivery[any]{}); got != tt.want {
+				t.Errorf("settled with %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeoutWaitsForHandler(t *testing.T) {
+	var running atomic.Int32
+	h := Timeout(10*time.Millisecond, NackRequeue)(func(ctx context.Context, d Delivery[any]) Acktype {
+		running.Add(1)
+		defer running.Add(-1)
+		// Ignores ctx, as a handler blocked on something else would.
+		time.Sleep(50 * time.Millisecond)
+		return NackRetry
+	})
+
+	if got := h(context.Background(), Delivery[any]{}); got != NackRequeue {
+		t.Errorf("settled with %v, want NackRequeue", got)
+	}
+	// The delivery is only settled, and so only redelivered, once the
+	// timed out attempt is over.
+	if n := running.Load(); n != 0 {
+		t.Errorf("%d handlers still running after the delivery was settled", n)
+	}
+}
+
+func TestTimeoutRepanics(t *testing.T) {
+	h := Recover()(Timeout(time.Second, NackRequeue)(func(ctx context.Context, d Delivery[any]) Acktype {
+		panic("boom")
+	}))
+	if got := h(context.Background(), Delivery[any]{}); got != NackDiscard {
+		t.Errorf("settled with %v, want NackDiscard", got)
+	}
+}
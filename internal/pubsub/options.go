@@ -0,0 +1,197 @@
+package pubsub
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+type SubscribeOption func(*subscribeOptions)
+
+type subscribeOptions struct {
+	decodeFailure DecodeFailurePolicy
+	retry         RetryPolicy
+	codecs        *CodecRegistry
+	dedup         DedupStore
+	workers       int
+	orderingKey   OrderingKey
+	prefetchCount int
+	consumerTag   string
+	exclusive     bool
+	singleActive  bool
+	priority      int
+	queueArgs     amqp.Table
+	bindingKeys   []string
+	middleware    []Middleware
+	noDeadLetter  bool
+}
+
+func newSubscribeOptions(opts []SubscribeOption) subscribeOptions {
+	o := subscribeOptions{
+		decodeFailure: DecodeFailureDeadLetter,
+		retry:         DefaultRetryPolicy,
+		codecs:        DefaultCodecs,
+		workers:       1,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// prefetchPerWorker keeps each worker busy while the next delivery is in
+// flight.
+const prefetchPerWorker = 10
+
+func (o subscribeOptions) prefetch() int {
+	if o.prefetchCount > 0 {
+		return o.prefetchCount
+	}
+	return prefetchPerWorker * o.workers
+}
+
+func (o subscribeOptions) queueArguments() amqp.Table {
+	args := amqp.Table{}
+	if !o.noDeadLetter {
+		args["x-dead-letter-exchange"] = routing.ExchangePerilDLX
+	}
+	for k, v := range o.queueArgs {
+		args[k] = v
+	}
+	if o.singleActive {
+		args["x-single-active-consumer"] = true
+	}
+	return args
+}
+
+func (o subscribeOptions) consumerArguments() amqp.Table {
+	if o.priority == 0 {
+		return nil
+	}
+	return amqp.Table{"x-priority": int32(o.priority)}
+}
+
+// WithDecodeFailurePolicy sets what happens to deliveries whose body cannot be
+// decoded. The default is DecodeFailureDeadLetter.
+func WithDecodeFailurePolicy(policy DecodeFailurePolicy) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.decodeFailure = policy
+	}
+}
+
+// WithRetryPolicy sets how deliveries settled with NackRetry are retried.
+func WithRetryPolicy(policy RetryPolicy) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.retry = policy
+	}
+}
+
+// WithCodecRegistry sets the codecs deliveries are decoded with. The default
+// is DefaultCodecs.
+func WithCodecRegistry(codecs *CodecRegistry) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.codecs = codecs
+	}
+}
+
+// WithDedup acks deliveries whose message ID is already in store without
+// running the handler, and marks each message the handler acks.
+func WithDedup(store DedupStore) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.dedup = store
+	}
+}
+
+// WithWorkers handles up to n deliveries at once, so the handler must be safe
+// for concurrent use. The channel's prefetch grows with n.
+func WithWorkers(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		if n < 1 {
+			n = 1
+		}
+		o.workers = n
+	}
+}
+
+// WithOrderingKey makes deliveries with the same key go to the same worker,
+// so they are handled in the order they arrived while deliveries with other
+// keys are handled in parallel. It has no effect without WithWorkers.
+func WithOrderingKey(key OrderingKey) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.orderingKey = key
+	}
+}
+
+// WithPrefetch sets how many unacked deliveries the broker sends ahead. The
+// default is 10 per worker.
+func WithPrefetch(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.prefetchCount = n
+	}
+}
+
+// WithConsumerTag names the consumer, so it can be told apart in the
+// management UI. The default is a random peril-<hex> tag.
+func WithConsumerTag(tag string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.consumerTag = tag
+	}
+}
+
+// WithExclusive makes the consumer the only one allowed on the queue.
+// Subscribing fails if the queue already has a consumer.
+func WithExclusive() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.exclusive = true
+	}
+}
+
+// WithSingleActiveConsumer declares the queue so only one of its consumers
+// receives deliveries at a time; the others take over if it goes away. The
+// queue must be declared this way from the start, because RabbitMQ refuses
+// to redeclare a queue with different arguments.
+func WithSingleActiveConsumer() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.singleActive = true
+	}
+}
+
+// WithPriority sets the consumer's priority. The broker delivers to
+// higher-priority consumers first while they have prefetch capacity.
+func WithPriority(priority int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.priority = priority
+	}
+}
+
+// WithQueueArgs adds arguments to the queue declaration, on top of the
+// dead-letter exchange every Peril queue gets.
+func WithQueueArgs(args amqp.Table) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.queueArgs = args
+	}
+}
+
+// WithBindingKeys binds the queue with keys as well as the subscription's own
+// key, for queues that collect messages matching more than one pattern.
+func WithBindingKeys(keys ...string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.bindingKeys = append(o.bindingKeys, keys...)
+	}
+}
+
+// withoutDeadLetters declares the queue without peril_dlx, for messages that
+// are worthless once they have not been handled, such as RPC requests.
+func withoutDeadLetters() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.noDeadLetter = true
+	}
+}
+
+// WithMiddleware wraps the handler in mws, the first outermost. Every
+// subscription also gets Recover outside of them.
+func WithMiddleware(mws ...Middleware) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.middleware = append(o.middleware, mws...)
+	}
+}
@@ -0,0 +1,128 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// recordingBroker hands out MemoryBroker channels that record how queues
+// are declared and consumed.
+type recordingBroker struct {
+	*MemoryBroker
+
+	mu       sync.Mutex
+	declares map[string]amqp.Table
+	prefetch int
+	consumes []recordedConsume
+}
+
+type recordedConsume struct {
+	queue, tag string
+	exclusive  bool
+	args       amqp.Table
+}
+
+func (b *recordingBroker) Channel() (Channel, error) {
+	ch, err := b.MemoryBroker.Channel()
+	if err != nil {
+		return nil, err
+	}
+	return &recordingChannel{Channel: ch, b: b}, nil
+}
+
+type recordingChannel struct {
+	Channel
+	b *recordingBroker
+}
+
+func (ch *recordingChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	ch.b.mu.Lock()
+	ch.b.declares[name] = args
+	ch.b.mu.Unlock()
+	return ch.Channel.QueueDeclare(name, durable, autoDelete, exclusive, noWait, args)
+}
+
+func (ch *recordingChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	ch.b.mu.Lock()
+	ch.b.prefetch = prefetchCount
+	ch.b.mu.Unlock()
+	return ch.Channel.Qos(prefetchCount, prefetchSize, global)
+}
+
+func (ch *recordingChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	ch.b.mu.Lock()
+	ch.b.consumes = append(ch.b.consumes, recordedConsume{queue: queue, tag: consumer, exclusive: exclusive, args: args})
+	ch.b.mu.Unlock()
+	return ch.Channel.Consume(queue, consumer, autoAck, exclusive, noLocal, noWait, args)
+}
+
+func TestSubscribeOptionsReachTheChannel(t *testing.T) {
+	b := &recordingBroker{MemoryBroker: NewMemoryBroker(), declares: map[string]amqp.Table{}}
+	defer b.Close()
+	ch := newTestChannel(t, b)
+	if err := ch.ExchangeDeclare("peril_topic", amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+		t.Fatalf("ExchangeDeclare: %v", err)
+	}
+
+	sub, err := SubscribeJSONWithContext(context.Background(), b, "peril_topic", "game_logs", "game_logs.*", QueueDurable, func(testMove) Acktype { return Ack },
+		WithPrefetch(3),
+		WithConsumerTag("peril-server.logs"),
+		WithExclusive(),
+		WithSingleActiveConsumer(),
+		WithPriority(5),
+		WithQueueArgs(amqp.Table{"x-max-length": int32(100)}),
+	)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Close()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wantArgs := amqp.Table{
+		"x-dead-letter-exchange":   "peril_dlx",
+		"x-single-active-consumer": true,
+		"x-max-length":             int32(100),
+	}
+	if got := b.declares["game_logs"]; fmt.Sprint(got) != fmt.Sprint(wantArgs) {
+		t.Errorf("game_logs declared with %v, want %v", got, wantArgs)
+	}
+	if b.prefetch != 3 {
+		t.Errorf("prefetch = %d, want 3", b.prefetch)
+	}
+	want := recordedConsume{queue: "game_logs", tag: "peril-server.logs", exclusive: true, args: amqp.Table{"x-priority": int32(5)}}
+	if len(b.consumes) != 1 || fmt.Sprint(b.consumes[0]) != fmt.Sprint(want) {
+		t.Errorf("consumed with %+v, want %+v", b.consumes, want)
+	}
+}
+
+func TestSubscribeDefaults(t *testing.T) {
+	b := &recordingBroker{MemoryBroker: NewMemoryBroker(), declares: map[string]amqp.Table{}}
+	defer b.Close()
+	ch := newTestChannel(t, b)
+	if err := ch.ExchangeDeclare("peril_topic", amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+		t.Fatalf("ExchangeDeclare: %v", err)
+	}
+
+	sub, err := SubscribeJSONWithContext(context.Background(), b, "peril_topic", "game_logs", "game_logs.*", QueueDurable, func(testMove) Acktype { return Ack }, WithWorkers(2))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Close()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if got := b.declares["game_logs"]; fmt.Sprint(got) != fmt.Sprint(amqp.Table{"x-dead-letter-exchange": "peril_dlx"}) {
+		t.Errorf("game_logs declared with %v, want only the dead-letter exchange", got)
+	}
+	if b.prefetch != 2*prefetchPerWorker {
+		t.Errorf("prefetch = %d, want %d for two workers", b.prefetch, 2*prefetchPerWorker)
+	}
+	if len(b.consumes) != 1 || b.consumes[0].exclusive || b.consumes[0].args != nil || len(b.consumes[0].tag) != len("peril-")+12 {
+		t.Errorf("consumed with %+v, want a shared peril-<hex> consumer with no arguments", b.consumes)
+	}
+}
@@ -0,0 +1,87 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+type DecodeFailurePolicy int
+
+const (
+	// DecodeFailureDeadLetter republishes the message to peril_dlx with
+	// diagnostic headers.
+	DecodeFailureDeadLetter DecodeFailurePolicy = iota
+	// DecodeFailureDiscard acks and drops the message.
+	DecodeFailureDiscard
+	// DecodeFailureQuarantine parks the message in a durable
+	// "<queue>.quarantine" queue with diagnostic headers.
+	DecodeFailureQuarantine
+)
+
+const (
+	HeaderDecodeError        = "x-decode-error"
+	HeaderDecodeFailedAt     = "x-decode-failed-at"
+	HeaderOriginalExchange   = "x-original-exchange"
+	HeaderOriginalRoutingKey = "x-original-routing-key"
+	HeaderOriginalQueue      = "x-original-queue"
+)
+
+func QuarantineQueueName(queueName string) string {
+	return queueName + ".quarantine"
+}
+
+func declareQuarantine(ch Channel, queueName string) error {
+	_, err := ch.QueueDeclare(QuarantineQueueName(queueName), true, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to declare quarantine queue: %v", err)
+	}
+	return nil
+}
+
+// handlePoison settles a delivery that could not be decoded. The original body
+// is kept as is; the decode error travels in the headers.
+func handlePoison(ch Publisher, delivery amqp.Delivery, queueName string, decodeErr error, policy DecodeFailurePolicy) {
+	if policy == DecodeFailureDiscard {
+		delivery.Ack(false)
+		return
+	}
+
+	headers := amqp.Table{}
+	for k, v := range delivery.Headers {
+		headers[k] = v
+	}
+	headers[HeaderDecodeError] = decodeErr.Error()
+	headers[HeaderDecodeFailedAt] = time.Now()
+	headers[HeaderOriginalExchange] = delivery.Exchange
+	headers[HeaderOriginalRoutingKey] = delivery.RoutingKey
+	headers[HeaderOriginalQueue] = queueName
+
+	exchange, key := routing.ExchangePerilDLX, delivery.RoutingKey
+	if policy == DecodeFailureQuarantine {
+		exchange, key = "", QuarantineQueueName(queueName)
+	}
+
+	err := ch.PublishWithContext(context.Background(), exchange, key, false, false, amqp.Publishing{
+		Headers:         headers,
+		ContentType:     delivery.ContentType,
+		ContentEncoding: delivery.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		CorrelationId:   delivery.CorrelationId,
+		MessageId:       delivery.MessageId,
+		Timestamp:       delivery.Timestamp,
+		Type:            delivery.Type,
+		AppId:           delivery.AppId,
+		Body:            delivery.Body,
+	})
+	if err != nil {
+		fmt.Printf("Failed to park undecodable message: %v\n", err)
+		delivery.Nack(false, false)
+		return
+	}
+	delivery.Ack(false)
+}
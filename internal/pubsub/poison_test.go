@@ -0,0 +1,96 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestDecodeFailurePolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy DecodeFailurePolicy
+		// parkedIn is the queue the undecodable message ends up in, if any.
+		parkedIn string
+	}{
+		{name: "dead-letter", policy: DecodeFailureDeadLetter, parkedIn: "peril_dlq"},
+		{name: "discard", policy: DecodeFailureDiscard},
+		{name: "quarantine", policy: DecodeFailureQuarantine, parkedIn: QuarantineQueueName("moves")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewMemoryBroker()
+			defer b.Close()
+			ch := newTestChannel(t, b)
+			if err := ch.ExchangeDeclare("peril_topic", amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+				t.Fatalf("ExchangeDeclare: %v", err)
+			}
+			declareDLQ(t, ch, "peril_dlx", "peril_dlq")
+
+			handled := make(chan testMove, 2)
+			ctx, cancel := context.WithCancel(context.Background())
+			sub, err := SubscribeJSONWithContext(ctx, b, "peril_topic", "moves", "army_moves.*", QueueDurable, func(m testMove) Acktype {
+				handled <- m
+				return Ack
+			}, WithDecodeFailurePolicy(tt.policy))
+			if err != nil {
+				t.Fatalf("Subscribe: %v", err)
+			}
+			defer func() {
+				cancel()
+				sub.Wait()
+			}()
+
+			publishBody(t, ch, "peril_topic", "army_moves.bob", "not json")
+			// Deliveries are handled in order, so once this one is the
+			// undecodable one has been dealt with.
+			if err := PublishJSON(ch, "peril_topic", "army_moves.bob", testMove{Player: "bob", To: "europe"}); err != nil {
+				t.Fatalf("PublishJSON: %v", err)
+			}
+			select {
+			case m := <-handled:
+				if m.To != "europe" {
+					t.Errorf("handler got %+v, want the move to europe", m)
+				}
+			case <-time.After(testTimeout):
+				t.Fatal("handler was not called")
+			}
+			if n := sub.PoisonCount(); n != 1 {
+				t.Errorf("PoisonCount = %d, want 1", n)
+			}
+
+			for _, queue := range []string{"peril_dlq", QuarantineQueueName("moves")} {
+				if queue == tt.parkedIn {
+					continue
+				}
+				if n := queueLength(t, ch, queue); n != 0 {
+					t.Errorf("%s holds %d messages, want none", queue, n)
+				}
+			}
+			if tt.parkedIn == "" {
+				return
+			}
+			d := receive(t, consume(t, ch, tt.parkedIn))
+			if string(d.Body) != "not json" {
+				t.Errorf("parked %q, want the original body", d.Body)
+			}
+			for header, want := range map[string]string{
+				HeaderOriginalExchange:   "peril_topic",
+				HeaderOriginalRoutingKey: "army_moves.bob",
+				HeaderOriginalQueue:      "moves",
+			} {
+				if got := d.Headers[header]; got != want {
+					t.Errorf("%s = %v, want %s", header, got, want)
+				}
+			}
+			if msg, _ := d.Headers[HeaderDecodeError].(string); msg == "" {
+				t.Errorf("%s is missing", HeaderDecodeError)
+			}
+			if _, ok := d.Headers[HeaderDecodeFailedAt].(time.Time); !ok {
+				t.Errorf("%s = %v, want a time", HeaderDecodeFailedAt, d.Headers[HeaderDecodeFailedAt])
+			}
+		})
+	}
+}
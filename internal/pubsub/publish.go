@@ -1,35 +1,73 @@
 package pubsub
 
 import (
-	"bytes"
 	"context"
-	"encoding/gob"
-	"encoding/json"
-
-	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-func PublishJSON[T any](ch *amqp.Channel, exchange, key string, val T) error {
-	body, err := json.Marshal(val)
-	if err != nil {
-		return err
+func PublishJSON[T any](ch Publisher, exchange, key string, val T) error {
+	return Publish(WithCodec(ch, JSONCodec{}), exchange, key, val)
+}
+
+func PublishGob[T any](ch Publisher, exchange, key string, val T) error {
+	return Publish(WithCodec(ch, GobCodec{}), exchange, key, val)
+}
+
+// Publish encodes val with ch's codec, set with WithCodec, or as JSON if it
+// has none.
+func Publish[T any](ch Publisher, exchange, key string, val T) error {
+	return PublishWithContext(context.Background(), ch, exchange, key, val)
+}
+
+// PublishWithContext is Publish with a context, which can carry the
+// correlation ID of the message being handled.
+func PublishWithContext[T any](ctx context.Context, ch Publisher, exchange, key string, val T) error {
+	err := publish(ctx, ch, exchange, key, val)
+	observePublish(exchange, key, err)
+	return err
+}
+
+// codecCarrier is implemented by publishers that choose the codec messages
+// published through them are encoded with.
+type codecCarrier interface {
+	publishCodec() Codec
+}
+
+func codecOf(pub Publisher) Codec {
+	if c, ok := pub.(codecCarrier); ok {
+		return c.publishCodec()
 	}
+	return JSONCodec{}
+}
 
-	return ch.PublishWithContext(context.Background(), exchange, key, false, false, amqp.Publishing{
-		ContentType: "application/json",
-		Body:        body,
-	})
+type codecPublisher struct {
+	Publisher
+	codec Codec
 }
 
-func PublishGob[T any](ch *amqp.Channel, exchange, key string, val T) error {
-	var buffer bytes.Buffer
-	encoder := gob.NewEncoder(&buffer)
-	if err := encoder.Encode(val); err != nil {
+// WithCodec wraps pub so Publish encodes every message published through it
+// with codec, so callers do not name a content type on every publish.
+func WithCodec(pub Publisher, codec Codec) Publisher {
+	return codecPublisher{Publisher: pub, codec: codec}
+}
+
+func (p codecPublisher) publishCodec() Codec { return p.codec }
+
+func publish(ctx context.Context, ch Publisher, exchange, key string, val interface{}) (err error) {
+	ctx, span := startPublishSpan(ctx, exchange, key)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	codec := codecOf(ch)
+	body, err := codec.Marshal(val)
+	if err != nil {
 		return err
 	}
 
-	return ch.PublishWithContext(context.Background(), exchange, key, false, false, amqp.Publishing{
-		ContentType: "application/gob",
-		Body:        buffer.Bytes(),
-	})
+	msg := newEnvelope(ctx, val)
+	msg.ContentType = codec.ContentType()
+	msg.Body = body
+	span.SetAttribute("messaging.message.id", msg.MessageId)
+	return ch.PublishWithContext(ctx, exchange, key, false, false, msg)
 }
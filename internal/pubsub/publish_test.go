@@ -0,0 +1,66 @@
+package pubsub
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestPublishUsesPublisherCodec(t *testing.T) {
+	tests := []struct {
+		name      string
+		publisher func(Channel) Publisher
+		want      string
+	}{
+		{
+			name:      "plain channel",
+			publisher: func(ch Channel) Publisher { return ch },
+			want:      ContentTypeJSON,
+		},
+		{
+			name:      "WithCodec",
+			publisher: func(ch Channel) Publisher { return WithCodec(ch, GobCodec{}) },
+			want:      ContentTypeGob,
+		},
+		{
+			name: "WithSender over WithCodec",
+			publisher: func(ch Channel) Publisher {
+				return WithSender(WithCodec(ch, MsgpackCodec{}), "peril-server", "server")
+			},
+			want: ContentTypeMsgpack,
+		},
+		{
+			name: "publish middleware",
+			publisher: func(ch Channel) Publisher {
+				return ChainPublisher(WithCodec(ch, CBORCodec{}), PublishLogging(slog.Default()), PublishTimeout(time.Second))
+			},
+			want: ContentTypeCBOR,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewMemoryBroker()
+			defer b.Close()
+			ch := newTestChannel(t, b)
+			if _, err := ch.QueueDeclare("work", true, false, false, false, nil); err != nil {
+				t.Fatalf("QueueDeclare: %v", err)
+			}
+			if err := Publish(tt.publisher(ch), "", "work", testMove{Player: "alice", To: "europe"}); err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+
+			d := receive(t, consume(t, ch, "work"))
+			if d.ContentType != tt.want {
+				t.Fatalf("published as %s, want %s", d.ContentType, tt.want)
+			}
+			codec, err := DefaultCodecs.Lookup(d.ContentType)
+			if err != nil {
+				t.Fatalf("Lookup: %v", err)
+			}
+			var got testMove
+			if err := codec.Unmarshal(d.Body, &got); err != nil || got.Player != "alice" {
+				t.Errorf("decoded %+v, %v, want alice's move", got, err)
+			}
+		})
+	}
+}
@@ -3,8 +3,6 @@ package pubsub
 import (
 	"fmt"
 
-	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
-
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
@@ -21,35 +19,92 @@ const (
 	Ack Acktype = iota
 	NackRequeue
 	NackDiscard
+	// NackRetry redelivers the message after a backoff delay, and
+	// dead-letters it once the subscription's RetryPolicy is exhausted.
+	NackRetry
 )
 
+func (a Acktype) String() string {
+	switch a {
+	case Ack:
+		return "ack"
+	case NackRequeue:
+		return "nack-requeue"
+	case NackDiscard:
+		return "nack-discard"
+	case NackRetry:
+		return "nack-retry"
+	default:
+		return fmt.Sprintf("acktype(%d)", int(a))
+	}
+}
+
+// DeclareAndBind declares a queue and binds it to exchange. Of the
+// SubscribeOptions, only those that shape the queue itself, such as
+// WithQueueArgs, WithSingleActiveConsumer and WithBindingKeys, apply here.
 func DeclareAndBind(
-	conn *amqp.Connection,
+	broker Broker,
 	exchange,
 	queueName,
 	key string,
 	queueType SimpleQueueType,
-) (*amqp.Channel, amqp.Queue, error) {
-	ch, err := conn.Channel()
+	opts ...SubscribeOption,
+) (Channel, amqp.Queue, error) {
+	ch, err := broker.Channel()
 	if err != nil {
 		return nil, amqp.Queue{}, fmt.Errorf("Failed to open a channel: %v", err)
 	}
 
-	isDurable := queueType == QueueDurable
-	isTransient := queueType == QueueTransient
+	o := newSubscribeOptions(opts)
+	args := o.queueArguments()
+	keys := append([]string{key}, o.bindingKeys...)
+	queue, err := declareAndBindOn(ch, exchange, queueName, keys, queueType, args)
+	if err != nil {
+		ch.Close()
+		return nil, amqp.Queue{}, err
+	}
 
-	args := amqp.Table{
-		"x-dead-letter-exchange": routing.ExchangePerilDLX,
+	if recorder, ok := broker.(declarationRecorder); ok && queueName != "" {
+		recorder.recordDeclaration(declaration{
+			exchange:  exchange,
+			queueName: queueName,
+			keys:      keys,
+			queueType: queueType,
+			args:      args,
+		})
 	}
 
+	return ch, queue, nil
+}
+
+type declaration struct {
+	exchange  string
+	queueName string
+	keys      []string
+	queueType SimpleQueueType
+	args      amqp.Table
+}
+
+// declarationRecorder is implemented by brokers that replay queue
+// declarations after reconnecting.
+type declarationRecorder interface {
+	recordDeclaration(d declaration)
+}
+
+func declareAndBindOn(ch Channel, exchange, queueName string, keys []string, queueType SimpleQueueType, args amqp.Table) (amqp.Queue, error) {
+	isDurable := queueType == QueueDurable
+	isTransient := queueType == QueueTransient
+
 	queue, err := ch.QueueDeclare(queueName, isDurable, isTransient, isTransient, false, args)
 	if err != nil {
-		return nil, amqp.Queue{}, fmt.Errorf("Failed to declare queue: %v", err)
+		return amqp.Queue{}, fmt.Errorf("Failed to declare queue: %v", err)
 	}
 
-	if err := ch.QueueBind(queue.Name, key, exchange, false, nil); err != nil {
-		return nil, amqp.Queue{}, fmt.Errorf("Failed to bind queue: %v", err)
+	for _, key := range keys {
+		if err := ch.QueueBind(queue.Name, key, exchange, false, nil); err != nil {
+			return amqp.Queue{}, fmt.Errorf("Failed to bind queue: %v", err)
+		}
 	}
 
-	return ch, queue, nil
+	return queue, nil
 }
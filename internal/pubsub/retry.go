@@ -0,0 +1,150 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+const HeaderRetryCount = "x-retry-count"
+
+// RetryPolicy controls how deliveries settled with NackRetry are retried.
+// Each distinct delay gets its own TTL queue, so Backoff should not use
+// jitter.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     Backoff
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	Backoff: Backoff{
+		Initial:    time.Second,
+		Max:        30 * time.Second,
+		Multiplier: 2,
+	},
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	return p.Backoff.Delay(attempt).Round(time.Millisecond)
+}
+
+// Delays lists the distinct delays the policy retries after, shortest first,
+// one per retry tier.
+func (p RetryPolicy) Delays() []time.Duration {
+	delays := []time.Duration{}
+	seen := map[time.Duration]bool{}
+	for attempt := 0; attempt < p.MaxAttempts; attempt++ {
+		delay := p.delay(attempt)
+		if !seen[delay] {
+			seen[delay] = true
+			delays = append(delays, delay)
+		}
+	}
+	return delays
+}
+
+// RetryQueueArgs are the arguments of the TTL queue holding retries for
+// delay.
+func RetryQueueArgs(delay time.Duration) amqp.Table {
+	return amqp.Table{
+		"x-message-ttl":          delay.Milliseconds(),
+		"x-dead-letter-exchange": "",
+	}
+}
+
+// RetryExchangeName names the fanout exchange, and the TTL queue bound to it,
+// that holds retries for the given delay.
+func RetryExchangeName(delay time.Duration) string {
+	return fmt.Sprintf("%s.%dms", routing.ExchangePerilRetry, delay.Milliseconds())
+}
+
+// declareRetryTiers declares one fanout exchange and TTL queue per delay the
+// policy can produce. Expired messages are dead-lettered to the default
+// exchange, which routes them back to the queue named by their routing key.
+func declareRetryTiers(ch Channel, policy RetryPolicy) error {
+	for _, delay := range policy.Delays() {
+		name := RetryExchangeName(delay)
+		if err := ch.ExchangeDeclare(name, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+			return fmt.Errorf("Failed to declare retry exchange: %v", err)
+		}
+		if _, err := ch.QueueDeclare(name, true, false, false, false, RetryQueueArgs(delay)); err != nil {
+			return fmt.Errorf("Failed to declare retry queue: %v", err)
+		}
+		if err := ch.QueueBind(name, "", name, false, nil); err != nil {
+			return fmt.Errorf("Failed to bind retry queue: %v", err)
+		}
+	}
+	return nil
+}
+
+// retryDelivery schedules delivery for another attempt on queueName, or
+// dead-letters it once the policy's attempts are used up. A retry that can
+// not be scheduled is dead-lettered too: requeueing it would only bring it
+// straight back to fail again.
+func retryDelivery(ch Publisher, delivery amqp.Delivery, queueName string, policy RetryPolicy) {
+	attempt, _ := tableInt(delivery.Headers[HeaderRetryCount])
+	if int(attempt) >= policy.MaxAttempts {
+		delivery.Nack(false, false)
+		return
+	}
+
+	headers := amqp.Table{}
+	for k, v := range delivery.Headers {
+		headers[k] = v
+	}
+	headers[HeaderRetryCount] = attempt + 1
+	if _, ok := headers[HeaderOriginalExchange]; !ok {
+		headers[HeaderOriginalExchange] = delivery.Exchange
+		headers[HeaderOriginalRoutingKey] = delivery.RoutingKey
+		headers[HeaderOriginalQueue] = queueName
+	}
+
+	delay := policy.delay(int(attempt))
+	err := ch.PublishWithContext(context.Background(), RetryExchangeName(delay), queueName, false, false, amqp.Publishing{
+		Headers:         headers,
+		ContentType:     delivery.ContentType,
+		ContentEncoding: delivery.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		CorrelationId:   delivery.CorrelationId,
+		ReplyTo:         delivery.ReplyTo,
+		MessageId:       delivery.MessageId,
+		Timestamp:       delivery.Timestamp,
+		Type:            delivery.Type,
+		AppId:           delivery.AppId,
+		Body:            delivery.Body,
+	})
+	if err != nil {
+		fmt.Printf("Failed to schedule retry, dead-lettering: %v\n", err)
+		delivery.Nack(false, false)
+		return
+	}
+	delivery.Ack(false)
+}
+
+func tableInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,108 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type failingPublisher struct{}
+
+func (failingPublisher) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	return errors.New("retry exchange unreachable")
+}
+
+func TestRetryDeliveryDeadLettersWhenRetryFails(t *testing.T) {
+	b := NewMemoryBroker()
+	defer b.Close()
+	ch := newTestChannel(t, b)
+	declareDLQ(t, ch, "dlx", "dlq")
+	if _, err := ch.QueueDeclare("work", true, false, false, false, amqp.Table{"x-dead-letter-exchange": "dlx"}); err != nil {
+		t.Fatalf("QueueDeclare: %v", err)
+	}
+	publishBody(t, ch, "", "work", "job")
+
+	work := consume(t, ch, "work")
+	d := receive(t, work)
+	retryDelivery(failingPublisher{}, d, "work", DefaultRetryPolicy)
+
+	if got := string(receive(t, consume(t, ch, "dlq")).Body); got != "job" {
+		t.Errorf("dead-lettered %q, want job", got)
+	}
+	select {
+	case d := <-work:
+		t.Errorf("%s was requeued after its retry failed", d.Body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestNackRetryRedeliversUntilDeadLettered(t *testing.T) {
+	b := NewMemoryBroker()
+	defer b.Close()
+	ch := newTestChannel(t, b)
+	if err := ch.ExchangeDeclare("peril_topic", amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+		t.Fatalf("ExchangeDeclare: %v", err)
+	}
+	declareDLQ(t, ch, "peril_dlx", "peril_dlq")
+
+	policy := RetryPolicy{
+		MaxAttempts: 2,
+		Backoff:     Backoff{Initial: 20 * time.Millisecond, Max: 40 * time.Millisecond, Multiplier: 2},
+	}
+	type attempt struct {
+		at    time.Time
+		count int64
+		key   string
+	}
+	attempts := make(chan attempt, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, err := SubscribeDeliveryWithContext(ctx, b, "peril_topic", "moves", "army_moves.*", QueueDurable, func(d Delivery[testMove]) Acktype {
+		count, _ := tableInt(d.Headers[HeaderRetryCount])
+		attempts <- attempt{at: time.Now(), count: count, key: d.RoutingKey}
+		return NackRetry
+	}, WithRetryPolicy(policy))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer func() {
+		cancel()
+		sub.Wait()
+	}()
+
+	if err := PublishJSON(ch, "peril_topic", "army_moves.bob", testMove{Player: "bob", To: "europe"}); err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+	var prev time.Time
+	for i := 0; i <= policy.MaxAttempts; i++ {
+		select {
+		case a := <-attempts:
+			if a.count != int64(i) {
+				t.Errorf("attempt %d has %s %d", i, HeaderRetryCount, a.count)
+			}
+			if a.key != "army_moves.bob" {
+				t.Errorf("attempt %d was routed with %q, want army_moves.bob", i, a.key)
+			}
+			if i > 0 {
+				if wait, delay := a.at.Sub(prev), policy.delay(i-1); wait < delay {
+					t.Errorf("attempt %d came %v after the last, before its %v delay", i, wait, delay)
+				}
+			}
+			prev = a.at
+		case <-time.After(testTimeout):
+			t.Fatalf("attempt %d was not delivered", i)
+		}
+	}
+
+	d := receive(t, consume(t, ch, "peril_dlq"))
+	if count, _ := tableInt(d.Headers[HeaderRetryCount]); count != int64(policy.MaxAttempts) {
+		t.Errorf("dead-lettered with %s %d, want %d", HeaderRetryCount, count, policy.MaxAttempts)
+	}
+	select {
+	case a := <-attempts:
+		t.Errorf("redelivered with %s %d after the last attempt", HeaderRetryCount, a.count)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
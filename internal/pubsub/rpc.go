@@ -0,0 +1,313 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// HeaderRPCError carries the error a Serve handler returned in place of a
+// response.
+const HeaderRPCError = "x-rpc-error"
+
+var (
+	ErrNoRPCServer     = errors.New("no server is listening for this request")
+	ErrRPCClientClosed = errors.New("rpc client closed")
+	// ErrRPCReplyQueueLost fails Calls waiting when the connection, and with
+	// it the reply queue, is lost.
+	ErrRPCReplyQueueLost = errors.New("rpc reply queue lost before the reply arrived")
+)
+
+// RPCError is an error returned by the handler on the other side of a Call.
+type RPCError struct {
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return "rpc: " + e.Message
+}
+
+type returnNotifier interface {
+	NotifyReturn(c chan amqp.Return) chan amqp.Return
+}
+
+// RPCClient sends requests and waits for their replies on an exclusive reply
+// queue. It is safe for concurrent use by multiple Calls. The reply queue
+// goes away with the connection; on a ManagedConnection the client declares a
+// new one once the connection is back, and Calls made in between fail with
+// ErrNotConnected.
+type RPCClient struct {
+	broker Broker
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu         sync.Mutex
+	ch         Channel
+	replyQueue string
+	returns    chan amqp.Return
+	// mandatory is set when the channel reports unroutable requests, so
+	// Call can fail fast instead of waiting out its context.
+	mandatory bool
+	pending   map[string]chan rpcResult
+	closed    bool
+}
+
+type rpcResult struct {
+	reply amqp.Delivery
+	err   error
+}
+
+func NewRPCClient(broker Broker) (*RPCClient, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &RPCClient{
+		broker:  broker,
+		ctx:     ctx,
+		cancel:  cancel,
+		pending: map[string]chan rpcResult{},
+	}
+	_, replies, err := c.open()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go c.run(replies)
+	return c, nil
+}
+
+// open declares a reply queue on a new channel and starts consuming it.
+func (c *RPCClient) open() (Channel, <-chan amqp.Delivery, error) {
+	ch, err := c.broker.Channel()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to open a channel: %v", err)
+	}
+	queue, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, nil, fmt.Errorf("Failed to declare reply queue: %v", err)
+	}
+	replies, err := ch.Consume(queue.Name, "", true, true, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, nil, fmt.Errorf("Failed to consume reply queue: %v", err)
+	}
+
+	var returns chan amqp.Return
+	if rn, ok := ch.(returnNotifier); ok {
+		returns = rn.NotifyReturn(make(chan amqp.Return, 1))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ch = ch
+	c.replyQueue = queue.Name
+	c.returns = returns
+	c.mandatory = returns != nil
+	return ch, replies, nil
+}
+
+func (c *RPCClient) run(replies <-chan amqp.Delivery) {
+	for {
+		c.mu.Lock()
+		returns := c.returns
+		c.mu.Unlock()
+		c.receive(replies, returns)
+
+		// The reply queue is gone, and with it the replies to every
+		// waiting Call.
+		c.mu.Lock()
+		if c.ch != nil {
+			c.ch.Close()
+		}
+		c.ch = nil
+		c.replyQueue = ""
+		c.mu.Unlock()
+		c.failPending(ErrRPCReplyQueueLost)
+
+		_, replies = restart(c.ctx, c.broker, c.open)
+		if replies == nil {
+			c.mu.Lock()
+			c.closed = true
+			c.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (c *RPCClient) receive(replies <-chan amqp.Delivery, returns <-chan amqp.Return) {
+	for {
+		select {
+		case reply, ok := <-replies:
+			if !ok {
+				return
+			}
+			c.resolve(reply.CorrelationId, rpcResult{reply: reply})
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			c.resolve(ret.MessageId, rpcResult{err: ErrNoRPCServer})
+		}
+	}
+}
+
+func (c *RPCClient) resolve(id string, result rpcResult) {
+	c.mu.Lock()
+	pending, ok := c.pending[id]
+	delete(c.pending, id)
+	c.mu.Unlock()
+	if ok {
+		pending <- result
+	}
+}
+
+func (c *RPCClient) failPending(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, pending := range c.pending {
+		pending <- rpcResult{err: err}
+		delete(c.pending, id)
+	}
+}
+
+func (c *RPCClient) Close() error {
+	c.cancel()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if c.ch == nil {
+		return nil
+	}
+	return c.ch.Close()
+}
+
+// Call publishes req as JSON to exchange with key and waits for the reply,
+// which is decoded with the codec for its ContentType. ctx bounds the wait;
+// on RabbitMQ its deadline also expires the request so a late server skips
+// it.
+func Call[Req, Resp any](ctx context.Context, c *RPCClient, exchange, key string, req Req) (Resp, error) {
+	var resp Resp
+	body, err := JSONCodec{}.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+
+	ctx, span := startPublishSpan(ctx, exchange, key)
+	defer span.End()
+	msg := newEnvelope(ctx, req)
+	msg.ContentType = ContentTypeJSON
+	msg.Body = body
+	if deadline, ok := ctx.Deadline(); ok {
+		ms := time.Until(deadline).Milliseconds()
+		if ms < 1 {
+			ms = 1
+		}
+		msg.Expiration = strconv.FormatInt(ms, 10)
+	}
+
+	pending := make(chan rpcResult, 1)
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return resp, ErrRPCClientClosed
+	}
+	if c.ch == nil {
+		c.mu.Unlock()
+		return resp, ErrNotConnected
+	}
+	ch, mandatory := c.ch, c.mandatory
+	msg.ReplyTo = c.replyQueue
+	c.pending[msg.MessageId] = pending
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, msg.MessageId)
+		c.mu.Unlock()
+	}()
+
+	err = ch.PublishWithContext(ctx, exchange, key, mandatory, false, msg)
+	observePublish(exchange, key, err)
+	if err != nil {
+		span.SetError(err)
+		return resp, err
+	}
+
+	select {
+	case result := <-pending:
+		if result.err != nil {
+			span.SetError(result.err)
+			return resp, result.err
+		}
+		reply := result.reply
+		if text, ok := reply.Headers[HeaderRPCError].(string); ok {
+			err := &RPCError{Message: text}
+			span.SetError(err)
+			return resp, err
+		}
+		codec, err := DefaultCodecs.Lookup(reply.ContentType)
+		if err != nil {
+			return resp, err
+		}
+		err = codec.Unmarshal(reply.Body, &resp)
+		return resp, err
+	case <-ctx.Done():
+		span.SetError(ctx.Err())
+		return resp, ctx.Err()
+	}
+}
+
+// Serve answers requests sent with Call. Each request is decoded into Req and
+// handler's response, or its error, is sent to the request's reply queue.
+// The request queue has no dead-letter exchange: requests that expire
+// before a server takes them, and requests without a reply queue, are
+// dropped rather than left in the DLQ.
+func Serve[Req, Resp any](ctx context.Context, broker Broker, exchange, queueName, key string, queueType SimpleQueueType, handler func(Delivery[Req]) (Resp, error), opts ...SubscribeOption) (*Subscription, error) {
+	replyCh, err := broker.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open a channel: %v", err)
+	}
+
+	sub, err := subscribe(ctx, broker, exchange, queueName, key, queueType, func(d Delivery[Req]) Acktype {
+		if d.ReplyTo == "" {
+			fmt.Printf("RPC request %s has no reply queue\n", d.MessageID)
+			return NackDiscard
+		}
+
+		resp, err := handler(d)
+		// The reply continues the request's conversation and trace.
+		replyCtx := ContextWithCorrelationID(d.Context(), d.MessageID)
+		msg := newEnvelope(replyCtx, resp)
+		if err != nil {
+			msg.Headers[HeaderRPCError] = err.Error()
+		} else {
+			body, err := JSONCodec{}.Marshal(resp)
+			if err != nil {
+				msg.Headers[HeaderRPCError] = fmt.Sprintf("could not encode response: %v", err)
+			} else {
+				msg.ContentType = ContentTypeJSON
+				msg.Body = body
+			}
+		}
+
+		if err := replyCh.PublishWithContext(replyCtx, "", d.ReplyTo, false, false, msg); err != nil {
+			fmt.Printf("Failed to reply to RPC request %s: %v\n", d.MessageID, err)
+			// The caller is still waiting, so let another server try.
+			return NackRequeue
+		}
+		return Ack
+	}, JSONCodec{}, append(opts, withoutDeadLetters()))
+	if err != nil {
+		replyCh.Close()
+		return nil, err
+	}
+
+	go func() {
+		<-sub.Done()
+		replyCh.Close()
+	}()
+	return sub, nil
+}
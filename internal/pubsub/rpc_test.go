@@ -0,0 +1,105 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type echoRequest struct {
+	Text string
+}
+
+func serveEcho(t *testing.T, broker Broker) {
+	t.Helper()
+	ch := newTestChannel(t, broker)
+	if err := ch.ExchangeDeclare("rpc", amqp.ExchangeDirect, true, false, false, false, nil); err != nil {
+		t.Fatalf("ExchangeDeclare: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, err := Serve(ctx, broker, "rpc", "rpc.echo", "rpc.echo", QueueDurable, func(d Delivery[echoRequest]) (string, error) {
+		if d.Value.Text == "" {
+			return "", errors.New("nothing to echo")
+		}
+		return d.Value.Text, nil
+	})
+	if err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		sub.Wait()
+	})
+}
+
+func callEcho(c *RPCClient, text string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	return Call[echoRequest, string](ctx, c, "rpc", "rpc.echo", echoRequest{Text: text})
+}
+
+func TestCallAndServe(t *testing.T) {
+	b := NewMemoryBroker()
+	defer b.Close()
+	serveEcho(t, b)
+	c, err := NewRPCClient(b)
+	if err != nil {
+		t.Fatalf("NewRPCClient: %v", err)
+	}
+	defer c.Close()
+
+	if got, err := callEcho(c, "hello"); err != nil || got != "hello" {
+		t.Errorf("Call = %q, %v, want hello", got, err)
+	}
+	var rpcErr *RPCError
+	if _, err := callEcho(c, ""); !errors.As(err, &rpcErr) || rpcErr.Message != "nothing to echo" {
+		t.Errorf("Call returned %v, want the handler's error", err)
+	}
+}
+
+func TestServeDeclaresRequestQueueWithoutDeadLetters(t *testing.T) {
+	b := NewMemoryBroker()
+	defer b.Close()
+	serveEcho(t, b)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if dlx, ok := b.queues["rpc.echo"].args["x-dead-letter-exchange"]; ok {
+		t.Errorf("request queue dead-letters to %v, want expired requests dropped", dlx)
+	}
+}
+
+func TestRPCClientRecoversAfterReconnect(t *testing.T) {
+	mc, dialer, b := newTestManagedConnection(t, ConnectionConfig{})
+	serveEcho(t, b)
+	c, err := NewRPCClient(mc)
+	if err != nil {
+		t.Fatalf("NewRPCClient: %v", err)
+	}
+	defer c.Close()
+
+	if _, err := callEcho(c, "before"); err != nil {
+		t.Fatalf("Call before the outage: %v", err)
+	}
+
+	dialer.drop(false)
+	waitDials(t, dialer, 2)
+	waitReady(t, mc)
+	// The client declares its new reply queue once it sees the connection
+	// is back, which may be just after this test does.
+	var got string
+	deadline := time.Now().Add(testTimeout)
+	for {
+		got, err = callEcho(c, "after")
+		if !errors.Is(err, ErrNotConnected) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err != nil || got != "after" {
+		t.Errorf("Call after reconnecting = %q, %v, want after", got, err)
+	}
+}
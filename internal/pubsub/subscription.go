@@ -0,0 +1,78 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Subscription is a running consumer started by one of the Subscribe
+// functions. Cancelling the context it was started with has the same effect
+// as calling Close.
+type Subscription struct {
+	tag    string
+	cancel context.CancelFunc
+	done   chan struct{}
+	poison atomic.Uint64
+
+	mu sync.Mutex
+	ch Channel
+}
+
+// Close cancels the consumer and the context handlers run with, lets the
+// handler finish the message it is working on, requeues anything else
+// already prefetched, and waits for the subscription to stop.
+func (s *Subscription) Close() error {
+	s.cancel()
+	s.Wait()
+	return nil
+}
+
+// Wait blocks until the subscription has stopped.
+func (s *Subscription) Wait() {
+	<-s.done
+}
+
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// PoisonCount is the number of deliveries that could not be decoded.
+func (s *Subscription) PoisonCount() uint64 {
+	return s.poison.Load()
+}
+
+func (s *Subscription) cancelOnDone(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-s.done:
+		return
+	}
+	s.cancelConsumer()
+}
+
+func (s *Subscription) cancelConsumer() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ch != nil {
+		if err := s.ch.Cancel(s.tag, false); err != nil {
+			s.ch.Close()
+			s.ch = nil
+		}
+	}
+}
+
+func (s *Subscription) setChannel(ch Channel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ch = ch
+}
+
+func (s *Subscription) closeChannel() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ch != nil {
+		s.ch.Close()
+		s.ch = nil
+	}
+}
@@ -0,0 +1,110 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// blockingSubscription subscribes to moves on peril_topic with a handler
+// that reports each delivery it starts on started and returns once release
+// is closed. Its handler's context is sent on started.
+func blockingSubscription(t *testing.T, ctx context.Context, b Broker, release <-chan struct{}) (*Subscription, <-chan context.Context) {
+	t.Helper()
+	ch := newTestChannel(t, b)
+	if err := ch.ExchangeDeclare("peril_topic", amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+		t.Fatalf("ExchangeDeclare: %v", err)
+	}
+	started := make(chan context.Context, 10)
+	sub, err := SubscribeDeliveryWithContext(ctx, b, "peril_topic", "moves", "army_moves.*", QueueDurable, func(d Delivery[testMove]) Acktype {
+		started <- d.Context()
+		<-release
+		return Ack
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	return sub, started
+}
+
+func TestSubscriptionCloseDrainsInFlightHandler(t *testing.T) {
+	b := NewMemoryBroker()
+	defer b.Close()
+	release := make(chan struct{})
+	sub, started := blockingSubscription(t, context.Background(), b, release)
+
+	ch := newTestChannel(t, b)
+	for _, to := range []string{"europe", "asia"} {
+		if err := PublishJSON(ch, "peril_topic", "army_moves.bob", testMove{Player: "bob", To: to}); err != nil {
+			t.Fatalf("PublishJSON: %v", err)
+		}
+	}
+	var hctx context.Context
+	select {
+	case hctx = <-started:
+	case <-time.After(testTimeout):
+		t.Fatal("handler was not called")
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		sub.Close()
+		close(closed)
+	}()
+	select {
+	case <-hctx.Done():
+	case <-time.After(testTimeout):
+		t.Fatal("closing the subscription did not cancel the handler's context")
+	}
+	select {
+	case <-closed:
+		t.Fatal("Close returned while the handler was still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(testTimeout):
+		t.Fatal("Close did not return after the handler finished")
+	}
+	select {
+	case <-started:
+		t.Error("the prefetched message was handled after Close")
+	default:
+	}
+	if n := queueLength(t, ch, "moves"); n != 1 {
+		t.Errorf("moves holds %d messages after Close, want the prefetched one requeued", n)
+	}
+}
+
+func TestSubscriptionStopsWhenItsContextIsCancelled(t *testing.T) {
+	b := NewMemoryBroker()
+	defer b.Close()
+	release := make(chan struct{})
+	close(release)
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, started := blockingSubscription(t, ctx, b, release)
+
+	cancel()
+	select {
+	case <-sub.Done():
+	case <-time.After(testTimeout):
+		t.Fatal("subscription did not stop when its context was cancelled")
+	}
+
+	ch := newTestChannel(t, b)
+	if err := PublishJSON(ch, "peril_topic", "army_moves.bob", testMove{Player: "bob", To: "europe"}); err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+	select {
+	case <-started:
+		t.Error("a message was handled after the subscription stopped")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if n := queueLength(t, ch, "moves"); n != 1 {
+		t.Errorf("moves holds %d messages, want 1 left for the next consumer", n)
+	}
+}
@@ -0,0 +1,49 @@
+package pubsub
+
+import (
+	"context"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/tracing"
+)
+
+// injectTrace adds the W3C trace context of the span in ctx to headers.
+func injectTrace(ctx context.Context, headers amqp.Table) {
+	sc := tracing.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	headers[HeaderTraceparent] = sc.Traceparent()
+	if sc.TraceState != "" {
+		headers[HeaderTracestate] = sc.TraceState
+	}
+}
+
+func startPublishSpan(ctx context.Context, exchange, key string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, "publish "+exchange+" "+key, tracing.SpanKindProducer)
+	span.SetAttribute("messaging.system", "rabbitmq")
+	span.SetAttribute("messaging.destination.name", exchange)
+	span.SetAttribute("messaging.rabbitmq.destination.routing_key", key)
+	return ctx, span
+}
+
+// startConsumeSpan starts the span a handler runs in, as a child of the
+// publisher's span when the delivery carries a traceparent.
+func startConsumeSpan(ctx context.Context, env Envelope, queue string) (context.Context, *tracing.Span) {
+	traceparent, _ := env.Headers[HeaderTraceparent].(string)
+	tracestate, _ := env.Headers[HeaderTracestate].(string)
+	if sc, err := tracing.ParseTraceparent(traceparent, tracestate); err == nil {
+		ctx = tracing.ContextWithRemoteSpanContext(ctx, sc)
+	}
+
+	ctx, span := tracing.Start(ctx, "consume "+queue, tracing.SpanKindConsumer)
+	span.SetAttribute("messaging.system", "rabbitmq")
+	span.SetAttribute("messaging.destination.name", queue)
+	span.SetAttribute("messaging.rabbitmq.destination.routing_key", env.RoutingKey)
+	span.SetAttribute("messaging.message.id", env.MessageID)
+	if env.Sender != "" {
+		span.SetAttribute("peril.sender", env.Sender)
+	}
+	return ctx, span
+}
@@ -0,0 +1,135 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/tracing"
+)
+
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (e *recordingExporter) Export(span tracing.SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// span waits for the span called name to end.
+func (e *recordingExporter) span(t *testing.T, name string) tracing.SpanData {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for time.Now().Before(deadline) {
+		e.mu.Lock()
+		for _, span := range e.spans {
+			if span.Name == name {
+				e.mu.Unlock()
+				return span
+			}
+		}
+		e.mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("span %q never ended", name)
+	return tracing.SpanData{}
+}
+
+// subscribeTraceparents subscribes queue to key on peril_topic and sends the
+// traceparent of each delivery on the returned channel, after calling then.
+func subscribeTraceparents(t *testing.T, ctx context.Context, b Broker, queue, key string, then func(Delivery[testMove])) <-chan string {
+	t.Helper()
+	traceparents := make(chan string, 1)
+	sub, err := SubscribeDeliveryWithContext(ctx, b, "peril_topic", queue, key, QueueTransient, func(d Delivery[testMove]) Acktype {
+		then(d)
+		traceparent, _ := d.Headers[HeaderTraceparent].(string)
+		traceparents <- traceparent
+		return Ack
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	t.Cleanup(func() { sub.Close() })
+	return traceparents
+}
+
+func TestTraceContextSurvivesARoundTrip(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracing.SetExporter(exporter)
+	t.Cleanup(func() { tracing.SetExporter(nil) })
+
+	b := NewMemoryBroker()
+	defer b.Close()
+	ch := newTestChannel(t, b)
+	if err := ch.ExchangeDeclare("peril_topic", amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+		t.Fatalf("ExchangeDeclare: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A move handler that logs the move, publishing from the delivery's
+	// context as handlers do.
+	moves := subscribeTraceparents(t, ctx, b, "moves", "army_moves.*", func(d Delivery[testMove]) {
+		if err := PublishWithContext(d.Context(), ch, "peril_topic", "game_logs.bob", d.Value); err != nil {
+			t.Errorf("PublishWithContext: %v", err)
+		}
+	})
+	logs := subscribeTraceparents(t, ctx, b, "logs", "game_logs.*", func(Delivery[testMove]) {})
+
+	rootCtx, root := tracing.Start(context.Background(), "move command", tracing.SpanKindInternal)
+	if err := PublishWithContext(rootCtx, ch, "peril_topic", "army_moves.bob", testMove{Player: "bob", To: "europe"}); err != nil {
+		t.Fatalf("PublishWithContext: %v", err)
+	}
+	root.End()
+
+	parse := func(traceparents <-chan string) tracing.SpanContext {
+		t.Helper()
+		select {
+		case traceparent := <-traceparents:
+			sc, err := tracing.ParseTraceparent(traceparent, "")
+			if err != nil {
+				t.Fatalf("ParseTraceparent(%q): %v", traceparent, err)
+			}
+			return sc
+		case <-time.After(testTimeout):
+			t.Fatal("handler was not called")
+			return tracing.SpanContext{}
+		}
+	}
+	move := parse(moves)
+	log := parse(logs)
+
+	traceID := root.SpanContext().TraceID
+	if move.TraceID != traceID || log.TraceID != traceID {
+		t.Errorf("move and log were traced as %s and %s, want both in %s", move.TraceID, log.TraceID, traceID)
+	}
+	if move.SpanID == log.SpanID {
+		t.Error("the log was published from the same span as the move")
+	}
+
+	// move command -> publish move -> consume moves -> publish log.
+	publishMove := exporter.span(t, "publish peril_topic army_moves.bob")
+	consumeMove := exporter.span(t, "consume moves")
+	publishLog := exporter.span(t, "publish peril_topic game_logs.bob")
+	for _, link := range []struct {
+		child  tracing.SpanData
+		parent string
+	}{
+		{publishMove, root.SpanContext().SpanID.String()},
+		{consumeMove, publishMove.SpanID},
+		{publishLog, consumeMove.SpanID},
+	} {
+		if link.child.TraceID != traceID.String() || link.child.ParentSpanID != link.parent {
+			t.Errorf("%s is in trace %s under %s, want trace %s under %s", link.child.Name, link.child.TraceID, link.child.ParentSpanID, traceID, link.parent)
+		}
+	}
+	if publishMove.SpanID != move.SpanID.String() || publishLog.SpanID != log.SpanID.String() {
+		t.Error("traceparent headers do not name the publish spans")
+	}
+}
@@ -0,0 +1,74 @@
+package pubsub
+
+import (
+	"hash/fnv"
+	"strings"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// OrderingKey picks the key WithOrderingKey keeps deliveries in order by.
+type OrderingKey func(Envelope) string
+
+// RoutingKeySuffix orders deliveries by everything after the first dot of
+// their routing key, which is the username for keys like game_logs.<username>.
+func RoutingKeySuffix(e Envelope) string {
+	_, suffix, _ := strings.Cut(e.RoutingKey, ".")
+	return suffix
+}
+
+// dispatch hands deliveries to n workers and returns once deliveries is closed
+// and every worker has finished. Each worker's queue holds a full prefetch,
+// so a slow key never stops deliveries with other keys from being handed out.
+func dispatch(deliveries <-chan amqp.Delivery, n, prefetch int, key OrderingKey, handle func(amqp.Delivery)) {
+	if n <= 1 {
+		for delivery := range deliveries {
+			handle(delivery)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	work := func(queue <-chan amqp.Delivery) {
+		defer wg.Done()
+		for delivery := range queue {
+			handle(delivery)
+		}
+	}
+
+	if key == nil {
+		queue := make(chan amqp.Delivery, prefetch)
+		wg.Add(n)
+		for i := 0; i < n; i++ {
+			go work(queue)
+		}
+		for delivery := range deliveries {
+			queue <- delivery
+		}
+		close(queue)
+		wg.Wait()
+		return
+	}
+
+	queues := make([]chan amqp.Delivery, n)
+	wg.Add(n)
+	for i := range queues {
+		queues[i] = make(chan amqp.Delivery, prefetch)
+		go work(queues[i])
+	}
+	for delivery := range deliveries {
+		queues[workerFor(key(envelopeFrom(delivery)), n)] <- delivery
+	}
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+}
+
+// workerFor picks which of n workers handles deliveries with key.
+func workerFor(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
@@ -0,0 +1,121 @@
+package pubsub
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const testWorkers = 4
+
+// keysOnDifferentWorkers finds two usernames whose game_logs keys are
+// handled by different workers.
+func keysOnDifferentWorkers(t *testing.T) (string, string) {
+	t.Helper()
+	first := "alice"
+	for _, other := range []string{"bob", "carol", "dave", "erin", "frank"} {
+		if workerFor(other, testWorkers) != workerFor(first, testWorkers) {
+			return first, other
+		}
+	}
+	t.Fatal("every username hashed to the same worker")
+	return "", ""
+}
+
+func logDelivery(username string, i int) amqp.Delivery {
+	return amqp.Delivery{RoutingKey: "game_logs." + username, Body: []byte(strconv.Itoa(i))}
+}
+
+func TestDispatchKeepsEachKeyInOrder(t *testing.T) {
+	deliveries := make(chan amqp.Delivery)
+	var mu sync.Mutex
+	handled := map[string][]int{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dispatch(deliveries, testWorkers, 10, RoutingKeySuffix, func(d amqp.Delivery) {
+			i, _ := strconv.Atoi(string(d.Body))
+			// Later deliveries finish faster, so only the ordering keeps
+			// them behind earlier ones.
+			time.Sleep(time.Duration(50-i) * 20 * time.Microsecond)
+			key := RoutingKeySuffix(envelopeFrom(d))
+			mu.Lock()
+			defer mu.Unlock()
+			handled[key] = append(handled[key], i)
+		})
+	}()
+
+	usernames := []string{"alice", "bob", "carol"}
+	for i := 0; i < 50; i++ {
+		deliveries <- logDelivery(usernames[i%len(usernames)], i)
+	}
+	close(deliveries)
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("dispatch did not return once deliveries was closed")
+	}
+
+	for j, username := range usernames {
+		want := []int{}
+		for i := j; i < 50; i += len(usernames) {
+			want = append(want, i)
+		}
+		if got := handled[username]; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s's logs were handled in the order %v, want %v", username, got, want)
+		}
+	}
+}
+
+func TestDispatchRunsOtherKeysWhileOneIsBlocked(t *testing.T) {
+	slow, fast := keysOnDifferentWorkers(t)
+	deliveries := make(chan amqp.Delivery, 3)
+	release := make(chan struct{})
+	handled := make(chan amqp.Delivery, 3)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dispatch(deliveries, testWorkers, 10, RoutingKeySuffix, func(d amqp.Delivery) {
+			if d.RoutingKey == "game_logs."+slow && string(d.Body) == "0" {
+				<-release
+			}
+			handled <- d
+		})
+	}()
+
+	deliveries <- logDelivery(slow, 0)
+	deliveries <- logDelivery(slow, 1)
+	deliveries <- logDelivery(fast, 2)
+	close(deliveries)
+
+	select {
+	case d := <-handled:
+		if d.RoutingKey != "game_logs."+fast {
+			t.Fatalf("%s was handled while %s's first log was blocked", d.Body, slow)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("%s's log waited on %s's", fast, slow)
+	}
+	select {
+	case d := <-handled:
+		t.Fatalf("%s was handled before %s's first log finished", d.Body, slow)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	for _, want := range []string{"0", "1"} {
+		select {
+		case d := <-handled:
+			if string(d.Body) != want {
+				t.Errorf("%s's log %s was handled, want %s", slow, d.Body, want)
+			}
+		case <-time.After(testTimeout):
+			t.Fatalf("%s's log %s was not handled", slow, want)
+		}
+	}
+	<-done
+}
@@ -2,8 +2,17 @@ package routing
 
 import "time"
 
+// PlayingState is owned by the server. Version grows with every change, so
+// receivers can ignore a state older than the one they already have.
 type PlayingState struct {
 	IsPaused bool
+	Version  uint64
+}
+
+// Supersedes reports whether ps should replace current. Unversioned states,
+// from servers that predate versioning, always apply.
+func (ps PlayingState) Supersedes(current PlayingState) bool {
+	return ps.Version == 0 || ps.Version > current.Version
 }
 
 type GameLog struct {
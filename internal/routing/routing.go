@@ -1,17 +1,35 @@
 package routing
 
 const (
-	ArmyMovesPrefix = "army_moves"
+	ArmyMovesPrefix  = "army_moves"
+	ArmySpawnsPrefix = "army_spawns"
 
+	// Wars are declared to the server as war.<attacker>.<defender>, and its
+	// results addressed to both players as war_results.<attacker>.<defender>.
 	WarRecognitionsPrefix = "war"
+	WarResultsPrefix      = "war_results"
 
 	PauseKey = "pause"
 
 	GameLogSlug = "game_logs"
+
+	// RPC requests are sent to peril_direct. Units requests are addressed to
+	// one player as rpc.units.<username>.
+	RPCPauseStateKey = "rpc.pause_state"
+	RPCRosterKey     = "rpc.roster"
+	RPCUnitsPrefix   = "rpc.units"
 )
 
 const (
 	ExchangePerilDirect = "peril_direct"
 	ExchangePerilTopic  = "peril_topic"
 	ExchangePerilDLX    = "peril_dlx"
+	ExchangePerilRetry  = "peril_retry"
+)
+
+const (
+	QueuePerilDLQ = "peril_dlq"
+	// QueuePerilPauseState retains the last pause broadcast, so a server
+	// that starts later knows the current state.
+	QueuePerilPauseState = "peril_pause_state"
 )
@@ -0,0 +1,136 @@
+package topology
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// inspect serves live the way the management API does and reads it back
+// with a ManagementInspector, so arguments come back decoded from JSON.
+func inspect(t *testing.T, live Topology) Topology {
+	t.Helper()
+	type exchange struct {
+		Name      string     `json:"name"`
+		Type      string     `json:"type"`
+		Durable   bool       `json:"durable"`
+		Arguments amqp.Table `json:"arguments"`
+	}
+	type queue struct {
+		Name      string     `json:"name"`
+		Durable   bool       `json:"durable"`
+		Arguments amqp.Table `json:"arguments"`
+	}
+	type binding struct {
+		Source          string `json:"source"`
+		Destination     string `json:"destination"`
+		DestinationType string `json:"destination_type"`
+		RoutingKey      string `json:"routing_key"`
+	}
+	exchanges, queues, bindings := []exchange{}, []queue{}, []binding{}
+	for _, ex := range live.Exchanges {
+		exchanges = append(exchanges, exchange{ex.Name, ex.Kind, ex.Durable, ex.Args})
+	}
+	for _, q := range live.Queues {
+		queues = append(queues, queue{q.Name, q.Durable, q.Args})
+	}
+	for _, b := range live.Bindings {
+		bindings = append(bindings, binding{b.Exchange, b.Queue, "queue", b.Key})
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "guest" || password != "guest" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var body interface{}
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/api/exchanges/"):
+			body = exchanges
+		case strings.HasPrefix(r.URL.Path, "/api/queues/"):
+			body = queues
+		case strings.HasPrefix(r.URL.Path, "/api/bindings/"):
+			body = bindings
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(body)
+	}))
+	defer srv.Close()
+
+	base := strings.Replace(srv.URL, "http://", "http://guest:guest@", 1)
+	got, err := ManagementInspector{BaseURL: base, Client: srv.Client()}.Inspect(context.Background())
+	if err != nil {
+		t.Fatalf("Inspect: %v", err)
+	}
+	return got
+}
+
+func driftStrings(drifts []Drift) []string {
+	out := []string{}
+	for _, d := range drifts {
+		out = append(out, d.String())
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestDiffPerilAgainstItself(t *testing.T) {
+	live := Peril()
+	// The broker's own exchanges and the queues clients declare are not
+	// drift.
+	live.Exchanges = append(live.Exchanges,
+		Exchange{Name: "", Kind: amqp.ExchangeDirect, Durable: true},
+		Exchange{Name: "amq.topic", Kind: amqp.ExchangeTopic, Durable: true},
+	)
+	live.Queues = append(live.Queues, Queue{Name: "army_moves.alice"})
+	live.Bindings = append(live.Bindings, Binding{Exchange: routing.ExchangePerilTopic, Queue: "army_moves.alice", Key: "army_moves.*"})
+
+	if drifts := Diff(Peril(), inspect(t, live)); len(drifts) != 0 {
+		t.Errorf("Diff = %v, want no drift", driftStrings(drifts))
+	}
+}
+
+func TestDiffReportsDrift(t *testing.T) {
+	live := Peril()
+	queues := []Queue{}
+	for _, q := range live.Queues {
+		switch q.Name {
+		case routing.WarRecognitionsPrefix:
+			continue
+		case routing.QueuePerilPauseState:
+			q.Args = amqp.Table{"x-max-length": 10}
+		}
+		queues = append(queues, q)
+	}
+	live.Queues = queues
+	bindings := []Binding{}
+	for _, b := range live.Bindings {
+		if b.Queue != routing.WarRecognitionsPrefix {
+			bindings = append(bindings, b)
+		}
+	}
+	live.Bindings = append(bindings, Binding{Exchange: routing.ExchangePerilTopic, Queue: routing.QueuePerilDLQ, Key: "#"})
+	live.Exchanges = append(live.Exchanges, Exchange{Name: "peril_legacy", Kind: amqp.ExchangeFanout, Durable: true})
+
+	want := []string{
+		`binding peril_topic -> peril_dlq ("#"): unexpected`,
+		`binding peril_topic -> war ("war.*.*"): missing`,
+		`exchange peril_legacy: unexpected`,
+		`queue peril_pause_state: arguments are map[x-max-length:10], want map[x-max-length:1]`,
+		`queue war: missing`,
+	}
+	if got := driftStrings(Diff(Peril(), inspect(t, live))); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff = %q, want %q", got, want)
+	}
+}
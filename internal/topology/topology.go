@@ -0,0 +1,124 @@
+package topology
+
+import (
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+type Exchange struct {
+	Name       string
+	Kind       string
+	Durable    bool
+	AutoDelete bool
+	Internal   bool
+	Args       amqp.Table
+}
+
+type Queue struct {
+	Name       string
+	Durable    bool
+	AutoDelete bool
+	Exclusive  bool
+	Args       amqp.Table
+}
+
+type Binding struct {
+	Exchange string
+	Queue    string
+	Key      string
+}
+
+// Topology is a desired set of exchanges, queues and bindings.
+type Topology struct {
+	Exchanges []Exchange
+	Queues    []Queue
+	Bindings  []Binding
+}
+
+// Declarer is the subset of a broker channel needed to apply a Topology.
+type Declarer interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+}
+
+// Peril describes the shared exchanges and queues of the game. Per-player
+// queues, such as each player's war results queue, are declared by the
+// clients when they subscribe.
+func Peril() Topology {
+	deadLettered := amqp.Table{
+		"x-dead-letter-exchange": routing.ExchangePerilDLX,
+	}
+	t := Topology{
+		Exchanges: []Exchange{
+			{Name: routing.ExchangePerilDirect, Kind: amqp.ExchangeDirect, Durable: true},
+			{Name: routing.ExchangePerilTopic, Kind: amqp.ExchangeTopic, Durable: true},
+			{Name: routing.ExchangePerilDLX, Kind: amqp.ExchangeFanout, Durable: true},
+		},
+		Queues: []Queue{
+			{Name: routing.QueuePerilDLQ, Durable: true},
+			// Only one server writes logs at a time, so each player's logs
+			// stay in order across multiserver.sh instances.
+			{Name: routing.GameLogSlug, Durable: true, Args: amqp.Table{
+				"x-dead-letter-exchange":   routing.ExchangePerilDLX,
+				"x-single-active-consumer": true,
+			}},
+			// War declarations, resolved by whichever server takes them.
+			{Name: routing.WarRecognitionsPrefix, Durable: true, Args: deadLettered},
+			// Holds only the newest pause broadcast.
+			{Name: routing.QueuePerilPauseState, Durable: true, Args: amqp.Table{
+				"x-max-length": int32(1),
+			}},
+		},
+		Bindings: []Binding{
+			{Exchange: routing.ExchangePerilDLX, Queue: routing.QueuePerilDLQ, Key: ""},
+			{Exchange: routing.ExchangePerilTopic, Queue: routing.GameLogSlug, Key: routing.GameLogSlug + ".*"},
+			{Exchange: routing.ExchangePerilTopic, Queue: routing.WarRecognitionsPrefix, Key: routing.WarRecognitionsPrefix + ".*.*"},
+			{Exchange: routing.ExchangePerilDirect, Queue: routing.QueuePerilPauseState, Key: routing.PauseKey},
+		},
+	}
+	// Subscriptions declare a retry tier per delay of their retry policy;
+	// these are the tiers of the default one.
+	for _, delay := range pubsub.DefaultRetryPolicy.Delays() {
+		name := pubsub.RetryExchangeName(delay)
+		t.Exchanges = append(t.Exchanges, Exchange{Name: name, Kind: amqp.ExchangeFanout, Durable: true})
+		t.Queues = append(t.Queues, Queue{Name: name, Durable: true, Args: pubsub.RetryQueueArgs(delay)})
+		t.Bindings = append(t.Bindings, Binding{Exchange: name, Queue: name, Key: ""})
+	}
+	return t
+}
+
+// Queue looks up a queue of t by name.
+func (t Topology) Queue(name string) (Queue, bool) {
+	for _, q := range t.Queues {
+		if q.Name == name {
+			return q, true
+		}
+	}
+	return Queue{}, false
+}
+
+// Apply declares everything in t. Declarations are idempotent, so it is safe
+// to call on every startup.
+func (t Topology) Apply(d Declarer) error {
+	for _, ex := range t.Exchanges {
+		if err := d.ExchangeDeclare(ex.Name, ex.Kind, ex.Durable, ex.AutoDelete, ex.Internal, false, ex.Args); err != nil {
+			return fmt.Errorf("Failed to declare exchange %s: %v", ex.Name, err)
+		}
+	}
+	for _, q := range t.Queues {
+		if _, err := d.QueueDeclare(q.Name, q.Durable, q.AutoDelete, q.Exclusive, false, q.Args); err != nil {
+			return fmt.Errorf("Failed to declare queue %s: %v", q.Name, err)
+		}
+	}
+	for _, b := range t.Bindings {
+		if err := d.QueueBind(b.Queue, b.Key, b.Exchange, false, nil); err != nil {
+			return fmt.Errorf("Failed to bind queue %s to %s: %v", b.Queue, b.Exchange, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Exporter receives every sampled span when it ends.
+type Exporter interface {
+	Export(span SpanData)
+}
+
+var (
+	exporterMu sync.RWMutex
+	exporter   Exporter
+)
+
+// SetExporter sets where finished spans go. With no exporter, trace context is
+// still propagated but spans are dropped.
+func SetExporter(e Exporter) {
+	exporterMu.Lock()
+	defer exporterMu.Unlock()
+	exporter = e
+}
+
+func currentExporter() Exporter {
+	exporterMu.RLock()
+	defer exporterMu.RUnlock()
+	return exporter
+}
+
+// FileExporter appends spans to a file as JSON lines. Several processes can
+// share one file, so a whole move, war and log flow ends up in one place.
+type FileExporter struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func NewFileExporter(path string) (*FileExporter, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open trace file: %v", err)
+	}
+	return &FileExporter{f: f}, nil
+}
+
+func (e *FileExporter) Export(span SpanData) {
+	line, err := json.Marshal(span)
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// One write per span keeps lines from different processes whole.
+	e.f.Write(append(line, '\n'))
+}
+
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.f.Close()
+}